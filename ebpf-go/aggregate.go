@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/cilium/ebpf"
+)
+
+/*
+Modalità aggregata (--aggregate).
+Per sessioni di profiling lunghe non serve vedere ogni singola syscall: il kernel conta
+quante volte ogni thread ha fatto una syscall con un certo stack (agg_map) e Go, a intervalli
+regolari, svuota la mappa e stampa gli stack più frequenti. Ogni stack viene cercato nella
+StackMap e risolto una sola volta, non una volta per evento.
+
+Svuotata la mappa, le syscall contate rilasciano i loro riferimenti agli stack: la
+StackTable può così liberare gli id che nessuna chiave usa più. Un id liberato può tornare
+con uno stack diverso, per questo i totali distinguono gli id anche per generazione.
+*/
+
+// Strutture gemelle di struct agg_key e struct agg_value in trace.c
+type aggKey struct {
+	Pid       uint32
+	Tid       uint32
+	SyscallId uint32
+	StackId   int32
+}
+
+type aggValue struct {
+	Count   uint64
+	TotalNs uint64
+}
+
+// totalKey identifica una riga dei totali: la stessa chiave con un id riusato è un altro stack
+type totalKey struct {
+	aggKey
+	gen uint32
+}
+
+// stackCount è una riga del report: una syscall con il suo stack già risolto
+type stackCount struct {
+	key     aggKey
+	label   string
+	count   uint64
+	totalNs uint64
+	frames  []string
+}
+
+type Aggregator struct {
+	aggMap  *ebpf.Map
+	stacks  *StackTable
+	targets *TargetSet
+	labels  *ThreadLabeler
+	top     int
+	total   map[totalKey]*stackCount // Totali dall'avvio, per il report finale
+}
+
+func NewAggregator(aggMap *ebpf.Map, stacks *StackTable, targets *TargetSet, labels *ThreadLabeler, top int) *Aggregator {
+	return &Aggregator{
+		aggMap:  aggMap,
+		stacks:  stacks,
+		targets: targets,
+		labels:  labels,
+		top:     top,
+		total:   make(map[totalKey]*stackCount),
+	}
+}
+
+// Drain legge e cancella i contatori accumulati dal kernel, li somma ai totali
+// e restituisce quelli dell'ultimo intervallo
+func (a *Aggregator) Drain() ([]*stackCount, error) {
+	// Prima raccogliamo le chiavi e poi cancelliamo: cancellare durante l'iterazione
+	// di una hash la fa ripartire da capo
+	var (
+		key    aggKey
+		val    aggValue
+		keys   []aggKey
+		counts []aggValue
+	)
+	iter := a.aggMap.Iterate()
+	for iter.Next(&key, &val) {
+		keys = append(keys, key)
+		counts = append(counts, val)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	interval := make([]*stackCount, 0, len(keys))
+	for i, key := range keys {
+		// Il kernel può aver incrementato il contatore dopo la lettura: rileggiamo e
+		// cancelliamo insieme, così quegli incrementi non vanno persi
+		val := counts[i]
+		var latest aggValue
+		if err := a.aggMap.LookupAndDelete(&key, &latest); err == nil {
+			val = latest
+		} else {
+			a.aggMap.Delete(&key)
+		}
+
+		entry := &stackCount{key: key, count: val.Count, totalNs: val.TotalNs}
+		tkey := totalKey{aggKey: key, gen: a.stacks.Generation(key.StackId)}
+		if prev, ok := a.total[tkey]; ok {
+			entry.label = prev.label
+			entry.frames = prev.frames
+			prev.count += val.Count
+			prev.totalNs += val.TotalNs
+		} else {
+			entry.label = a.labels.Label(key.Pid, key.Tid, "?")
+			entry.frames = a.resolve(key)
+			a.total[tkey] = &stackCount{key: key, label: entry.label, count: val.Count, totalNs: val.TotalNs, frames: entry.frames}
+		}
+		// Ogni syscall contata aveva preso un riferimento allo stack al sys_enter
+		a.stacks.ReleaseN(key.StackId, val.Count)
+		interval = append(interval, entry)
+	}
+	return interval, nil
+}
+
+// resolve recupera lo stack dalla StackMap e lo traduce con il Symbolizer del processo.
+// Lo stesso stack in più thread (o più syscall) dello stesso processo si risolve una volta sola
+func (a *Aggregator) resolve(key aggKey) []string {
+	names, err := a.stacks.Resolve(key.Pid, key.StackId, a.targets.Symbolizer(key.Pid))
+	if err != nil {
+		return []string{fmt.Sprintf("(stack non disponibile: %v)", err)}
+	}
+	return names
+}
+
+// PrintTop stampa gli stack più frequenti, ordinati per numero di chiamate
+func (a *Aggregator) PrintTop(title string, entries []*stackCount) {
+	if len(entries) == 0 {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].count != entries[j].count {
+			return entries[i].count > entries[j].count
+		}
+		return entries[i].totalNs > entries[j].totalNs
+	})
+
+	var calls uint64
+	for _, e := range entries {
+		calls += e.count
+	}
+	fmt.Printf("\n📈 %s: %d syscall, %d stack distinti\n", title, calls, len(entries))
+
+	if len(entries) > a.top {
+		entries = entries[:a.top]
+	}
+	for i, e := range entries {
+		fmt.Printf("   #%-3d %8dx %-20s %10s nel kernel | PID %d | TID %d [%s] | Stack ID: %d\n",
+			i+1, e.count, getSyscallName(e.key.SyscallId), time.Duration(e.totalNs).Round(time.Microsecond),
+			e.key.Pid, e.key.Tid, e.label, e.key.StackId)
+		for j, name := range e.frames {
+			fmt.Printf("      [%2d] %s\n", j, name)
+		}
+	}
+}
+
+// PrintSummary stampa la classifica dall'avvio del tracer
+func (a *Aggregator) PrintSummary() {
+	entries := make([]*stackCount, 0, len(a.total))
+	for _, e := range a.total {
+		entries = append(entries, e)
+	}
+	a.PrintTop("Top syscall stack dall'avvio", entries)
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+Traduzione degli argomenti grezzi (args[6]) in una stringa leggibile.
+Il tipo e il nome di ogni argomento arrivano da tracefs (vedi tracefs.go);
+per i flag più usati (open, mmap, socket...) stampiamo i nomi simbolici
+invece del numero, così O_RDONLY|O_CLOEXEC si distingue subito da O_WRONLY|O_CREAT.
+*/
+
+// flagName associa un bit (o un gruppo di bit) al suo nome simbolico
+type flagName struct {
+	value uint64
+	name  string
+}
+
+// argDecoder trasforma il valore grezzo di un argomento nella sua rappresentazione testuale
+type argDecoder func(v uint64) string
+
+// O_TMPFILE include il bit di O_DIRECTORY: va controllato per primo
+var openFlags = []flagName{
+	{unix.O_TMPFILE, "O_TMPFILE"}, {unix.O_CREAT, "O_CREAT"}, {unix.O_EXCL, "O_EXCL"}, {unix.O_NOCTTY, "O_NOCTTY"},
+	{unix.O_TRUNC, "O_TRUNC"}, {unix.O_APPEND, "O_APPEND"}, {unix.O_NONBLOCK, "O_NONBLOCK"},
+	{unix.O_SYNC, "O_SYNC"}, {unix.O_DSYNC, "O_DSYNC"}, {unix.O_ASYNC, "O_ASYNC"},
+	{unix.O_DIRECT, "O_DIRECT"}, {unix.O_LARGEFILE, "O_LARGEFILE"}, {unix.O_DIRECTORY, "O_DIRECTORY"},
+	{unix.O_NOFOLLOW, "O_NOFOLLOW"}, {unix.O_NOATIME, "O_NOATIME"}, {unix.O_CLOEXEC, "O_CLOEXEC"},
+	{unix.O_PATH, "O_PATH"},
+}
+
+var protFlags = []flagName{
+	{unix.PROT_READ, "PROT_READ"}, {unix.PROT_WRITE, "PROT_WRITE"}, {unix.PROT_EXEC, "PROT_EXEC"},
+	{unix.PROT_GROWSDOWN, "PROT_GROWSDOWN"}, {unix.PROT_GROWSUP, "PROT_GROWSUP"},
+}
+
+var mmapFlags = []flagName{
+	{unix.MAP_SHARED_VALIDATE, "MAP_SHARED_VALIDATE"}, {unix.MAP_SHARED, "MAP_SHARED"},
+	{unix.MAP_PRIVATE, "MAP_PRIVATE"}, {unix.MAP_FIXED, "MAP_FIXED"}, {unix.MAP_ANONYMOUS, "MAP_ANONYMOUS"},
+	{unix.MAP_GROWSDOWN, "MAP_GROWSDOWN"}, {unix.MAP_DENYWRITE, "MAP_DENYWRITE"},
+	{unix.MAP_EXECUTABLE, "MAP_EXECUTABLE"}, {unix.MAP_LOCKED, "MAP_LOCKED"},
+	{unix.MAP_NORESERVE, "MAP_NORESERVE"}, {unix.MAP_POPULATE, "MAP_POPULATE"},
+	{unix.MAP_NONBLOCK, "MAP_NONBLOCK"}, {unix.MAP_STACK, "MAP_STACK"}, {unix.MAP_HUGETLB, "MAP_HUGETLB"},
+	{unix.MAP_FIXED_NOREPLACE, "MAP_FIXED_NOREPLACE"},
+}
+
+var atFlags = []flagName{
+	{unix.AT_SYMLINK_NOFOLLOW, "AT_SYMLINK_NOFOLLOW"}, {unix.AT_REMOVEDIR, "AT_REMOVEDIR"},
+	{unix.AT_SYMLINK_FOLLOW, "AT_SYMLINK_FOLLOW"}, {unix.AT_NO_AUTOMOUNT, "AT_NO_AUTOMOUNT"},
+	{unix.AT_EMPTY_PATH, "AT_EMPTY_PATH"}, {unix.AT_EACCESS, "AT_EACCESS"},
+}
+
+var accessModes = []flagName{
+	{unix.R_OK, "R_OK"}, {unix.W_OK, "W_OK"}, {unix.X_OK, "X_OK"},
+}
+
+// Flag accettati da pipe2, dup3, accept4, eventfd2, epoll_create1...
+var cloexecFlags = []flagName{
+	{unix.O_CLOEXEC, "O_CLOEXEC"}, {unix.O_NONBLOCK, "O_NONBLOCK"}, {unix.O_DIRECT, "O_DIRECT"},
+}
+
+var socketTypeFlags = []flagName{
+	{unix.SOCK_NONBLOCK, "SOCK_NONBLOCK"}, {unix.SOCK_CLOEXEC, "SOCK_CLOEXEC"},
+}
+
+var socketTypes = map[uint64]string{
+	unix.SOCK_STREAM: "SOCK_STREAM", unix.SOCK_DGRAM: "SOCK_DGRAM", unix.SOCK_RAW: "SOCK_RAW",
+	unix.SOCK_SEQPACKET: "SOCK_SEQPACKET",
+}
+
+var addressFamilies = map[uint64]string{
+	unix.AF_UNSPEC: "AF_UNSPEC", unix.AF_UNIX: "AF_UNIX", unix.AF_INET: "AF_INET",
+	unix.AF_INET6: "AF_INET6", unix.AF_NETLINK: "AF_NETLINK", unix.AF_PACKET: "AF_PACKET",
+}
+
+var madviseAdvice = map[uint64]string{
+	unix.MADV_NORMAL: "MADV_NORMAL", unix.MADV_RANDOM: "MADV_RANDOM", unix.MADV_SEQUENTIAL: "MADV_SEQUENTIAL",
+	unix.MADV_WILLNEED: "MADV_WILLNEED", unix.MADV_DONTNEED: "MADV_DONTNEED", unix.MADV_FREE: "MADV_FREE",
+	unix.MADV_HUGEPAGE: "MADV_HUGEPAGE", unix.MADV_NOHUGEPAGE: "MADV_NOHUGEPAGE",
+	unix.MADV_DONTFORK: "MADV_DONTFORK", unix.MADV_DODUMP: "MADV_DODUMP", unix.MADV_DONTDUMP: "MADV_DONTDUMP",
+}
+
+// Operazioni futex (include/uapi/linux/futex.h), non presenti in x/sys/unix
+const (
+	futexWait          = 0
+	futexWake          = 1
+	futexFd            = 2
+	futexRequeue       = 3
+	futexCmpRequeue    = 4
+	futexWakeOp        = 5
+	futexLockPI        = 6
+	futexUnlockPI      = 7
+	futexTrylockPI     = 8
+	futexWaitBitset    = 9
+	futexWakeBitset    = 10
+	futexPrivateFlag   = 128
+	futexClockRealtime = 256
+)
+
+var futexOps = map[uint64]string{
+	futexWait: "FUTEX_WAIT", futexWake: "FUTEX_WAKE", futexFd: "FUTEX_FD",
+	futexRequeue: "FUTEX_REQUEUE", futexCmpRequeue: "FUTEX_CMP_REQUEUE",
+	futexWakeOp: "FUTEX_WAKE_OP", futexLockPI: "FUTEX_LOCK_PI",
+	futexUnlockPI: "FUTEX_UNLOCK_PI", futexTrylockPI: "FUTEX_TRYLOCK_PI",
+	futexWaitBitset: "FUTEX_WAIT_BITSET", futexWakeBitset: "FUTEX_WAKE_BITSET",
+}
+
+// Decodificatori specifici, indicizzati per "syscall.argomento".
+// I nomi degli argomenti sono quelli di tracefs.
+var argDecoders = map[string]argDecoder{
+	"open.flags":           decodeOpenFlags,
+	"openat.flags":         decodeOpenFlags,
+	"open.mode":            decodeOctal,
+	"openat.mode":          decodeOctal,
+	"creat.mode":           decodeOctal,
+	"mkdir.mode":           decodeOctal,
+	"mkdirat.mode":         decodeOctal,
+	"chmod.mode":           decodeOctal,
+	"fchmod.mode":          decodeOctal,
+	"fchmodat.mode":        decodeOctal,
+	"access.mode":          decodeAccessMode,
+	"faccessat.mode":       decodeAccessMode,
+	"faccessat2.mode":      decodeAccessMode,
+	"faccessat2.flags":     decodeBitFlags(atFlags),
+	"newfstatat.flag":      decodeBitFlags(atFlags),
+	"statx.flags":          decodeBitFlags(atFlags),
+	"unlinkat.flag":        decodeBitFlags(atFlags),
+	"fchownat.flag":        decodeBitFlags(atFlags),
+	"linkat.flags":         decodeBitFlags(atFlags),
+	"utimensat.flags":      decodeBitFlags(atFlags),
+	"execveat.flags":       decodeBitFlags(atFlags),
+	"mmap.prot":            decodeProt,
+	"mprotect.prot":        decodeProt,
+	"pkey_mprotect.prot":   decodeProt,
+	"mmap.flags":           decodeBitFlags(mmapFlags),
+	"madvise.behavior":     decodeEnum(madviseAdvice),
+	"pipe2.flags":          decodeBitFlags(cloexecFlags),
+	"dup3.flags":           decodeBitFlags(cloexecFlags),
+	"eventfd2.flags":       decodeBitFlags(cloexecFlags),
+	"epoll_create1.flags":  decodeBitFlags(cloexecFlags),
+	"inotify_init1.flags":  decodeBitFlags(cloexecFlags),
+	"accept4.flags":        decodeBitFlags(socketTypeFlags),
+	"socket.family":        decodeEnum(addressFamilies),
+	"socketpair.family":    decodeEnum(addressFamilies),
+	"socket.type":          decodeSocketType,
+	"socketpair.type":      decodeSocketType,
+	"futex.op":             decodeFutexOp,
+	"timerfd_create.flags": decodeBitFlags(cloexecFlags),
+}
+
+// Nomi di argomento che contengono sempre un file descriptor, qualunque sia la syscall
+var fdArgNames = map[string]bool{"fd": true, "dfd": true, "olddfd": true, "newdfd": true, "oldfd": true, "newfd": true, "epfd": true}
+
+// decodeBitFlags restituisce un decodificatore che elenca i flag attivi separati da '|'.
+// I bit non riconosciuti vengono aggiunti in esadecimale in fondo.
+func decodeBitFlags(table []flagName) argDecoder {
+	return func(v uint64) string {
+		var names []string
+		rest := v
+		for _, f := range table {
+			if f.value != 0 && rest&f.value == f.value {
+				names = append(names, f.name)
+				rest &^= f.value
+			}
+		}
+		if rest != 0 || len(names) == 0 {
+			names = append(names, fmt.Sprintf("0x%x", rest))
+		}
+		return strings.Join(names, "|")
+	}
+}
+
+// decodeEnum restituisce un decodificatore per argomenti che assumono un solo valore tra tanti
+func decodeEnum(table map[uint64]string) argDecoder {
+	return func(v uint64) string {
+		if name, ok := table[v]; ok {
+			return name
+		}
+		return strconv.FormatUint(v, 10)
+	}
+}
+
+// decodeOpenFlags gestisce a parte la modalità di accesso, che non è un bit ma un valore (0, 1 o 2)
+func decodeOpenFlags(v uint64) string {
+	var mode string
+	switch v & unix.O_ACCMODE {
+	case unix.O_RDONLY:
+		mode = "O_RDONLY"
+	case unix.O_WRONLY:
+		mode = "O_WRONLY"
+	case unix.O_RDWR:
+		mode = "O_RDWR"
+	default:
+		mode = "O_ACCMODE"
+	}
+	rest := v &^ unix.O_ACCMODE
+	if rest == 0 {
+		return mode
+	}
+	return mode + "|" + decodeBitFlags(openFlags)(rest)
+}
+
+func decodeProt(v uint64) string {
+	if v == unix.PROT_NONE {
+		return "PROT_NONE"
+	}
+	return decodeBitFlags(protFlags)(v)
+}
+
+func decodeAccessMode(v uint64) string {
+	if v == unix.F_OK {
+		return "F_OK"
+	}
+	return decodeBitFlags(accessModes)(v)
+}
+
+func decodeSocketType(v uint64) string {
+	base := decodeEnum(socketTypes)(v &^ (unix.SOCK_NONBLOCK | unix.SOCK_CLOEXEC))
+	if extra := v & (unix.SOCK_NONBLOCK | unix.SOCK_CLOEXEC); extra != 0 {
+		return base + "|" + decodeBitFlags(socketTypeFlags)(extra)
+	}
+	return base
+}
+
+// decodeFutexOp separa il comando dai modificatori FUTEX_PRIVATE_FLAG e FUTEX_CLOCK_REALTIME
+func decodeFutexOp(v uint64) string {
+	name := decodeEnum(futexOps)(v &^ (futexPrivateFlag | futexClockRealtime))
+	if v&futexPrivateFlag != 0 {
+		name += "|FUTEX_PRIVATE_FLAG"
+	}
+	if v&futexClockRealtime != 0 {
+		name += "|FUTEX_CLOCK_REALTIME"
+	}
+	return name
+}
+
+func decodeOctal(v uint64) string {
+	return fmt.Sprintf("0%o", v)
+}
+
+func decodeFd(v uint64) string {
+	fd := int32(v)
+	if fd == unix.AT_FDCWD {
+		return "AT_FDCWD"
+	}
+	return strconv.Itoa(int(fd))
+}
+
+// isSignedType riconosce i tipi C con segno. Non ci fidiamo solo di "signed:" del file
+// di formato, perché per gli argomenti delle syscall il kernel lo riporta spesso a 0.
+func isSignedType(arg SyscallArg) bool {
+	if arg.Signed {
+		return true
+	}
+	switch arg.Type {
+	case "int", "long", "pid_t", "off_t", "loff_t", "clockid_t", "key_serial_t", "ssize_t":
+		return true
+	}
+	return false
+}
+
+// decodeArg applica il decodificatore più adatto ad un singolo argomento
+func decodeArg(syscallName string, arg SyscallArg, v uint64) string {
+	if dec, ok := argDecoders[syscallName+"."+arg.Name]; ok {
+		return dec(v)
+	}
+	if fdArgNames[arg.Name] {
+		return decodeFd(v)
+	}
+	// Puntatori in esadecimale (NULL se zero)
+	if strings.Contains(arg.Type, "*") {
+		if v == 0 {
+			return "NULL"
+		}
+		return fmt.Sprintf("0x%x", v)
+	}
+	if isSignedType(arg) {
+		// Gli int occupano 8 byte nel record ma solo i 4 bassi sono significativi
+		if arg.Type == "int" || arg.Type == "pid_t" || arg.Type == "clockid_t" || arg.Type == "key_serial_t" {
+			return strconv.FormatInt(int64(int32(v)), 10)
+		}
+		return strconv.FormatInt(int64(v), 10)
+	}
+	// Valori molto grandi (indirizzi, maschere) sono più leggibili in esadecimale
+	if v > 0xffff {
+		return fmt.Sprintf("0x%x", v)
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+// FormatArgs produce "nome=valore, ..." per tutti gli argomenti della syscall.
+// Se tracefs non è disponibile (o la syscall non ha un formato) stampiamo i 6 registri grezzi.
+func (s SyscallSchemas) FormatArgs(id uint32, raw [6]uint64) string {
+	name := getSyscallName(id)
+	schema, ok := s.Lookup(name)
+	if !ok {
+		parts := make([]string, len(raw))
+		for i, v := range raw {
+			parts[i] = fmt.Sprintf("arg%d=0x%x", i, v)
+		}
+		return strings.Join(parts, ", ")
+	}
+	parts := make([]string, 0, len(schema))
+	for i, arg := range schema {
+		if i >= len(raw) {
+			break
+		}
+		parts = append(parts, fmt.Sprintf("%s=%s", arg.Name, decodeArg(name, arg, raw[i])))
+	}
+	return strings.Join(parts, ", ")
+}
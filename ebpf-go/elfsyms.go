@@ -0,0 +1,251 @@
+package main
+
+import (
+	"debug/elf"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unicode"
+
+	"github.com/ianlancetaylor/demangle"
+)
+
+/*
+Indice dei simboli di un file ELF.
+La tabella dei simboli di node ne contiene centinaia di migliaia: la decodifichiamo una
+sola volta per binario, teniamo solo le funzioni ordinate per indirizzo e cerchiamo con
+una ricerca binaria. Il demangling dei nomi C++ è costoso, quindi lo facciamo solo per
+i simboli che compaiono davvero negli stack, e lo ricordiamo.
+L'indice è condiviso tra tutti i Symbolizer: i processi figli e i worker con lo stesso
+binario node non lo ricostruiscono.
+*/
+
+type elfSymbol struct {
+	Value uint64
+	Size  uint64
+	Name  string // Nome originale (mangled per il C++)
+}
+
+// elfLoad è un segmento PT_LOAD: i byte [Offset, Offset+Filesz) del file sono mappati
+// all'indirizzo virtuale Vaddr (quello usato dai simboli)
+type elfLoad struct {
+	Offset uint64
+	Vaddr  uint64
+	Filesz uint64
+}
+
+type ELFSymbols struct {
+	loads     []elfLoad
+	symbols   []elfSymbol
+	maxEnd    []uint64 // maxEnd[i] è la fine più alta tra symbols[0..i], per i simboli sovrapposti
+	demangled []string // Nomi demangled già calcolati ("" = non ancora)
+	mu        sync.Mutex
+}
+
+// elfKey identifica un file sul disco: se un pacchetto aggiorna node o una libreria
+// nello stesso percorso, inode e data di modifica cambiano e l'indice viene ricostruito
+type elfKey struct {
+	path  string
+	dev   uint64
+	ino   uint64
+	mtime int64
+}
+
+var elfIndexes = struct {
+	sync.Mutex
+	byFile map[elfKey]*ELFSymbols
+	byPath map[string]elfKey // Versione del file indicizzata per ogni percorso
+}{byFile: make(map[elfKey]*ELFSymbols), byPath: make(map[string]elfKey)}
+
+// loadELFSymbols restituisce l'indice del file, costruendolo alla prima richiesta
+func loadELFSymbols(path string) (*ELFSymbols, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	key := elfKey{path: path, mtime: info.ModTime().UnixNano()}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		key.dev, key.ino = st.Dev, st.Ino
+	}
+
+	elfIndexes.Lock()
+	defer elfIndexes.Unlock()
+	if idx, ok := elfIndexes.byFile[key]; ok {
+		return idx, nil
+	}
+	idx, err := buildELFSymbols(path)
+	if err != nil {
+		return nil, err
+	}
+	// Il file è stato sostituito: l'indice della versione precedente non serve più.
+	// I Symbolizer che lo stanno usando tengono il loro riferimento
+	if old, ok := elfIndexes.byPath[path]; ok {
+		delete(elfIndexes.byFile, old)
+	}
+	elfIndexes.byFile[key] = idx
+	elfIndexes.byPath[path] = key
+	return idx, nil
+}
+
+// buildELFSymbols legge le tabelle dei simboli (sia quella standard che quella dinamica
+// delle .so) e tiene solo le funzioni con un indirizzo e una dimensione
+func buildELFSymbols(path string) (*ELFSymbols, error) {
+	file, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	symbols, _ := file.Symbols()
+	dynSymbols, _ := file.DynamicSymbols()
+	symbols = append(symbols, dynSymbols...)
+
+	idx := &ELFSymbols{}
+	for _, prog := range file.Progs {
+		if prog.Type == elf.PT_LOAD {
+			idx.loads = append(idx.loads, elfLoad{Offset: prog.Off, Vaddr: prog.Vaddr, Filesz: prog.Filesz})
+		}
+	}
+	for _, sym := range symbols {
+		if elf.ST_TYPE(sym.Info) != elf.STT_FUNC || sym.Value == 0 || sym.Size == 0 {
+			continue
+		}
+		idx.symbols = append(idx.symbols, elfSymbol{Value: sym.Value, Size: sym.Size, Name: sym.Name})
+	}
+	sort.Slice(idx.symbols, func(i, j int) bool { return idx.symbols[i].Value < idx.symbols[j].Value })
+
+	idx.maxEnd = make([]uint64, len(idx.symbols))
+	idx.demangled = make([]string, len(idx.symbols))
+	var maxEnd uint64
+	for i, sym := range idx.symbols {
+		maxEnd = max(maxEnd, sym.Value+sym.Size)
+		idx.maxEnd[i] = maxEnd
+	}
+	return idx, nil
+}
+
+// FileOffsetToVaddr traduce un offset nel file (quello che si ricava da /proc/<PID>/maps:
+// ip - inizio della regione + offset della regione) nell'indirizzo virtuale dei simboli, con
+// il segmento PT_LOAD che lo contiene: vaddr = offset - p_offset + p_vaddr.
+// Offset e indirizzo coincidono solo se p_vaddr == p_offset, cosa che non vale per i binari
+// non-PIE (es. p_vaddr 0x400000), per le librerie prelinkate e per i segmenti con p_vaddr
+// spostato rispetto a p_offset. Senza PT_LOAD (file anomalo) restituiamo l'offset così com'è
+func (e *ELFSymbols) FileOffsetToVaddr(offset uint64) (uint64, bool) {
+	if len(e.loads) == 0 {
+		return offset, true
+	}
+	for _, load := range e.loads {
+		if offset >= load.Offset && offset < load.Offset+load.Filesz {
+			return offset - load.Offset + load.Vaddr, true
+		}
+	}
+	return 0, false
+}
+
+// LookupOffset cerca la funzione che contiene un offset nel file
+func (e *ELFSymbols) LookupOffset(offset uint64) (string, bool) {
+	if e == nil {
+		return "", false
+	}
+	vaddr, ok := e.FileOffsetToVaddr(offset)
+	if !ok {
+		return "", false
+	}
+	return e.Lookup(vaddr)
+}
+
+// Lookup cerca la funzione che contiene addr (indirizzo virtuale del file) e ne restituisce il nome demangled.
+// Un indice nil (file non leggibile) non trova nulla
+func (e *ELFSymbols) Lookup(addr uint64) (string, bool) {
+	if e == nil {
+		return "", false
+	}
+	i := sort.Search(len(e.symbols), func(i int) bool { return e.symbols[i].Value > addr })
+	for i--; i >= 0 && e.maxEnd[i] > addr; i-- {
+		if addr < e.symbols[i].Value+e.symbols[i].Size {
+			return e.Demangled(i), true
+		}
+	}
+	return "", false
+}
+
+// Demangled restituisce il nome dell'i-esimo simbolo senza i lunghi argomenti delle funzioni C++
+// (demangle.NoParams). Se il demangling fallisce, ad esempio per una funzione C come "__open64"
+// che non è mangled, resta il nome originale
+func (e *ELFSymbols) Demangled(i int) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if name := e.demangled[i]; name != "" {
+		return name
+	}
+	name, err := demangle.ToString(e.symbols[i].Name, demangle.NoParams)
+	if err != nil {
+		name = e.symbols[i].Name
+	}
+	e.demangled[i] = name
+	return name
+}
+
+// Find restituisce i nomi originali delle funzioni che si chiamano name, confrontando sia
+// il nome originale sia quello demangled (es. tutti gli overload di node::fs::Open).
+// Si demangla solo chi può corrispondere: nel nome mangled ogni componente di name
+// compare preceduto dalla sua lunghezza (node::fs::Open -> _ZN4node2fs4Open...)
+func (e *ELFSymbols) Find(name string) []string {
+	var parts []string
+	for _, part := range strings.Split(name, "::") {
+		// Operatori, template e distruttori non hanno questa forma nel nome mangled,
+		// e neanche std e i suoi tipi abbreviati (St, Ss, So...)
+		if !stdAbbreviations[part] && isSourceName(part) {
+			parts = append(parts, strconv.Itoa(len(part))+part)
+		}
+	}
+
+	seen := make(map[string]bool)
+	var matches []string
+	for i, sym := range e.symbols {
+		if sym.Name == name {
+			return []string{sym.Name}
+		}
+		if seen[sym.Name] || !containsAll(sym.Name, parts) {
+			continue
+		}
+		if e.Demangled(i) == name {
+			matches = append(matches, sym.Name)
+			seen[sym.Name] = true
+		}
+	}
+	return matches
+}
+
+// Componenti che il mangling Itanium scrive con un'abbreviazione (St, Sa, Sb, Ss, Si, So, Sd)
+var stdAbbreviations = map[string]bool{
+	"std": true, "allocator": true, "basic_string": true, "string": true,
+	"istream": true, "ostream": true, "iostream": true,
+	"basic_istream": true, "basic_ostream": true, "basic_iostream": true,
+}
+
+// isSourceName dice se s è un identificatore C/C++, che il mangling scrive come <lunghezza><nome>
+func isSourceName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if c != '_' && !unicode.IsLetter(c) && (i == 0 || !unicode.IsDigit(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// containsAll dice se s contiene tutte le sottostringhe
+func containsAll(s string, subs []string) bool {
+	for _, sub := range subs {
+		if !strings.Contains(s, sub) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestELF scrive un ELF x86_64 minimo: i segmenti PT_LOAD indicati e una .symtab con
+// le funzioni indicate. Il contenuto dei segmenti non serve, l'indice legge solo le intestazioni
+func writeTestELF(t *testing.T, typ elf.Type, loads []elfLoad, funcs []elfSymbol) string {
+	t.Helper()
+	const (
+		ehsize    = 64
+		phentsize = 56
+		shentsize = 64
+		symsize   = 24
+	)
+
+	// Tabella delle stringhe dei simboli e tabella dei simboli (la prima voce è nulla)
+	strtab := []byte{0}
+	syms := make([]elf.Sym64, 1, len(funcs)+1)
+	for _, fn := range funcs {
+		syms = append(syms, elf.Sym64{
+			Name:  uint32(len(strtab)),
+			Info:  elf.ST_INFO(elf.STB_GLOBAL, elf.STT_FUNC),
+			Shndx: 1,
+			Value: fn.Value,
+			Size:  fn.Size,
+		})
+		strtab = append(strtab, fn.Name...)
+		strtab = append(strtab, 0)
+	}
+	shstrtab := []byte("\x00.symtab\x00.strtab\x00.shstrtab\x00")
+
+	symOff := uint64(ehsize + phentsize*len(loads))
+	strOff := symOff + uint64(symsize*len(syms))
+	shstrOff := strOff + uint64(len(strtab))
+	shOff := shstrOff + uint64(len(shstrtab))
+
+	var buf bytes.Buffer
+	write := func(v any) {
+		if err := binary.Write(&buf, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hdr := elf.Header64{
+		Type:      uint16(typ),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Phoff:     ehsize,
+		Shoff:     shOff,
+		Ehsize:    ehsize,
+		Phentsize: phentsize,
+		Phnum:     uint16(len(loads)),
+		Shentsize: shentsize,
+		Shnum:     4,
+		Shstrndx:  3,
+	}
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	write(hdr)
+	for _, load := range loads {
+		write(elf.Prog64{
+			Type:   uint32(elf.PT_LOAD),
+			Flags:  uint32(elf.PF_R | elf.PF_X),
+			Off:    load.Offset,
+			Vaddr:  load.Vaddr,
+			Paddr:  load.Vaddr,
+			Filesz: load.Filesz,
+			Memsz:  load.Filesz,
+			Align:  0x1000,
+		})
+	}
+	write(syms)
+	buf.Write(strtab)
+	buf.Write(shstrtab)
+	write([]elf.Section64{
+		{},
+		{Name: 1, Type: uint32(elf.SHT_SYMTAB), Off: symOff, Size: uint64(symsize * len(syms)), Link: 2, Info: 1, Entsize: symsize},
+		{Name: 9, Type: uint32(elf.SHT_STRTAB), Off: strOff, Size: uint64(len(strtab))},
+		{Name: 17, Type: uint32(elf.SHT_STRTAB), Off: shstrOff, Size: uint64(len(shstrtab))},
+	})
+
+	path := filepath.Join(t.TempDir(), "fixture")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLookupOffset(t *testing.T) {
+	type lookup struct {
+		offset uint64
+		want   string // "" = nessuna funzione
+	}
+	tests := []struct {
+		name    string
+		typ     elf.Type
+		loads   []elfLoad
+		funcs   []elfSymbol
+		lookups []lookup
+	}{
+		{
+			// PIE con il testo spostato di una pagina rispetto al file (p_vaddr != p_offset)
+			name: "pie",
+			typ:  elf.ET_DYN,
+			loads: []elfLoad{
+				{Offset: 0, Vaddr: 0, Filesz: 0x1000},
+				{Offset: 0x1000, Vaddr: 0x2000, Filesz: 0x1000},
+			},
+			funcs: []elfSymbol{
+				{Value: 0x2100, Size: 0x40, Name: "pie_func"},
+				{Value: 0x1100, Size: 0x40, Name: "decoy"}, // Dove finirebbe usando l'offset come indirizzo
+			},
+			lookups: []lookup{
+				{0x1100, "pie_func"},
+				{0x113f, "pie_func"},
+				{0x1140, ""},
+			},
+		},
+		{
+			// Libreria condivisa come la produce lld (o gold): il testo segue i dati in sola
+			// lettura nel file ma parte dalla pagina successiva in memoria
+			name: "shared-lib",
+			typ:  elf.ET_DYN,
+			loads: []elfLoad{
+				{Offset: 0, Vaddr: 0, Filesz: 0x5a0},
+				{Offset: 0x5a0, Vaddr: 0x15a0, Filesz: 0x800},
+			},
+			funcs: []elfSymbol{
+				{Value: 0x200, Size: 0x40, Name: "ro_func"},
+				{Value: 0x1600, Size: 0x80, Name: "so_func"},
+				{Value: 0x600, Size: 0x80, Name: "decoy"}, // Dove finirebbe usando l'offset come indirizzo
+			},
+			lookups: []lookup{
+				{0x210, "ro_func"}, // Primo segmento: offset e indirizzo coincidono
+				{0x5a0, ""},        // Inizio del testo, prima di ogni funzione
+				{0x600, "so_func"},
+				{0x67f, "so_func"},
+				{0xda0, ""}, // Oltre la fine del testo
+			},
+		},
+		{
+			// Eseguibile non-PIE caricato a 0x400000
+			name:  "non-pie",
+			typ:   elf.ET_EXEC,
+			loads: []elfLoad{{Offset: 0, Vaddr: 0x400000, Filesz: 0x2000}},
+			funcs: []elfSymbol{
+				{Value: 0x401000, Size: 0x100, Name: "main"},
+				{Value: 0x401100, Size: 0x20, Name: "helper"},
+			},
+			lookups: []lookup{
+				{0x1000, "main"},
+				{0x1050, "main"},
+				{0x1100, "helper"},
+				{0x2000, ""}, // Oltre la fine dell'unico PT_LOAD
+			},
+		},
+		{
+			// Libreria prelinkata: indirizzi assoluti scelti da prelink, due segmenti lontani
+			name: "prelinked-so",
+			typ:  elf.ET_DYN,
+			loads: []elfLoad{
+				{Offset: 0, Vaddr: 0x3a000000, Filesz: 0x3000},
+				{Offset: 0x3000, Vaddr: 0x3a203000, Filesz: 0x1000},
+			},
+			funcs: []elfSymbol{
+				{Value: 0x3a001200, Size: 0x80, Name: "lib_func"},
+				{Value: 0x3a203100, Size: 0x10, Name: "late_func"},
+			},
+			lookups: []lookup{
+				{0x1240, "lib_func"},
+				{0x3100, "late_func"},
+				{0x3110, ""},
+				{0x5000, ""}, // In nessun segmento
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx, err := loadELFSymbols(writeTestELF(t, tt.typ, tt.loads, tt.funcs))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, l := range tt.lookups {
+				name, ok := idx.LookupOffset(l.offset)
+				if name != l.want || ok != (l.want != "") {
+					t.Errorf("LookupOffset(0x%x) = %q, %v; want %q", l.offset, name, ok, l.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFileOffsetToVaddrOutsideSegments(t *testing.T) {
+	idx, err := loadELFSymbols(writeTestELF(t, elf.ET_DYN, []elfLoad{
+		{Offset: 0, Vaddr: 0, Filesz: 0x1000},
+		{Offset: 0x2000, Vaddr: 0x3000, Filesz: 0x1000},
+	}, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Il buco tra i due segmenti non è mappato da nessun PT_LOAD
+	if vaddr, ok := idx.FileOffsetToVaddr(0x1800); ok {
+		t.Errorf("FileOffsetToVaddr(0x1800) = 0x%x, true; want false", vaddr)
+	}
+	if vaddr, ok := idx.FileOffsetToVaddr(0x2010); !ok || vaddr != 0x3010 {
+		t.Errorf("FileOffsetToVaddr(0x2010) = 0x%x, %v; want 0x3010, true", vaddr, ok)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+Audit dei processi lanciati dall'applicazione Node (child_process.exec/spawn/fork...).
+Per un IDS è l'evento più importante: una libreria compromessa che esegue "curl ... | sh"
+deve comparire subito, insieme alla funzione JavaScript che l'ha fatto partire.
+*/
+
+// Struttura gemella di struct exec_event in trace.c
+type ExecEvent struct {
+	Type        uint32
+	Pid         uint32
+	Ppid        uint32
+	StackId     int32
+	TimestampNs uint64
+	Dirfd       int32
+	Argc        uint32
+	Envc        uint32
+	Tid         uint32
+	Filename    [256]byte
+	Argv        [16][128]byte
+	Envp        [16][64]byte
+}
+
+// Struttura gemella di struct exec_result in trace.c
+type ExecResult struct {
+	Type        uint32
+	Pid         uint32
+	Tid         uint32
+	Ret         int32
+	TimestampNs uint64
+}
+
+// execThread identifica il thread che ha chiamato execve
+type execThread struct {
+	pid uint32
+	tid uint32
+}
+
+// execAttempt è un exec annunciato dal sys_enter, in attesa dell'esito. Lo stack viene
+// risolto subito: quando arriva l'esito il Symbolizer del processo può essere già cambiato
+type execAttempt struct {
+	ev        ExecEvent
+	eventTime time.Time
+	binPath   string
+	names     []string
+}
+
+// ExecAuditor stampa ogni exec riuscito e tiene il conteggio dei comandi per funzione JS
+type ExecAuditor struct {
+	// funzione JS -> riga di comando -> numero di esecuzioni
+	byFunction map[string]map[string]int
+	// Exec in attesa di sapere se sono riusciti
+	attempts map[execThread]*execAttempt
+	// Binario dell'ultimo exec di ogni thread, in attesa dell'evento di sys_exit della syscall
+	pending map[execThread]string
+	// Tentativi falliti (es. execvp che prova ogni directory del PATH)
+	failed int
+}
+
+func NewExecAuditor() *ExecAuditor {
+	return &ExecAuditor{
+		byFunction: make(map[string]map[string]int),
+		attempts:   make(map[execThread]*execAttempt),
+		pending:    make(map[execThread]string),
+	}
+}
+
+// CommandLine ricostruisce la riga di comando dagli argomenti catturati
+func (e *ExecEvent) CommandLine() string {
+	args := make([]string, 0, e.Argc)
+	for i := 0; i < int(e.Argc) && i < len(e.Argv); i++ {
+		args = append(args, cString(e.Argv[i][:]))
+	}
+	return strings.Join(args, " ")
+}
+
+// EnvKeys restituisce solo i nomi delle variabili d'ambiente: i valori
+// (token, password...) non devono finire nell'output del tracer
+func (e *ExecEvent) EnvKeys() []string {
+	keys := make([]string, 0, e.Envc)
+	for i := 0; i < int(e.Envc) && i < len(e.Envp); i++ {
+		key, _, _ := strings.Cut(cString(e.Envp[i][:]), "=")
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// firstJSFunction cerca nello stack risolto il frame JavaScript più vicino alla syscall
+func firstJSFunction(names []string) string {
+	for _, name := range names {
+		if strings.HasPrefix(name, "[JS] ") {
+			return strings.TrimPrefix(name, "[JS] ")
+		}
+	}
+	return "(nessuna funzione JS nello stack)"
+}
+
+// Handle registra un exec appena chiamato: viene stampato e contato solo quando
+// HandleResult conferma che è riuscito
+func (a *ExecAuditor) Handle(ev ExecEvent, eventTime time.Time, symb *Symbolizer, frames []uint64) {
+	key := execThread{pid: ev.Pid, tid: ev.Tid}
+	binPath := resolvePath(ev.Pid, cString(ev.Filename[:]), ev.Dirfd)
+	a.pending[key] = binPath
+
+	names := make([]string, len(frames))
+	for i, ip := range frames {
+		names[i] = symb.Resolve(ip)
+	}
+	a.attempts[key] = &execAttempt{ev: ev, eventTime: eventTime, binPath: binPath, names: names}
+}
+
+// HandleResult stampa l'exec con il suo stack e lo aggiunge al riepilogo se è riuscito.
+// I tentativi falliti vengono solo contati
+func (a *ExecAuditor) HandleResult(res ExecResult) {
+	key := execThread{pid: res.Pid, tid: res.Tid}
+	attempt, ok := a.attempts[key]
+	if !ok {
+		return
+	}
+	delete(a.attempts, key)
+	if res.Ret != 0 {
+		a.failed++
+		return
+	}
+
+	ev := attempt.ev
+	cmdline := ev.CommandLine()
+	fmt.Printf("\n🕒 [%s] 🚀 Exec: PID %d TID %d (padre %d) | Stack ID: %d\n",
+		attempt.eventTime.Format("15:04:05.000000"), ev.Pid, ev.Tid, ev.Ppid, ev.StackId)
+	fmt.Printf("      📦 Binario: %s\n", attempt.binPath)
+	fmt.Printf("      💬 Comando: %s\n", cmdline)
+	if keys := ev.EnvKeys(); len(keys) > 0 {
+		fmt.Printf("      🔑 Ambiente: %s\n", strings.Join(keys, ", "))
+	}
+	for i, name := range attempt.names {
+		fmt.Printf("      [%2d] %s\n", i, name)
+	}
+
+	jsFunc := firstJSFunction(attempt.names)
+	if a.byFunction[jsFunc] == nil {
+		a.byFunction[jsFunc] = make(map[string]int)
+	}
+	if cmdline == "" {
+		cmdline = attempt.binPath
+	}
+	a.byFunction[jsFunc][cmdline]++
+}
+
+// TakeBinary restituisce il binario dell'exec che il thread ha appena chiamato, per la riga "File:"
+// dell'evento di execve/execveat. L'exec_event parte dal sys_enter e arriva quindi prima
+func (a *ExecAuditor) TakeBinary(pid, tid uint32) string {
+	key := execThread{pid: pid, tid: tid}
+	binPath := a.pending[key]
+	delete(a.pending, key)
+	return binPath
+}
+
+// Forget scarta gli exec in attesa di un processo terminato (es. execve escluse da --syscalls,
+// di cui non arriverà mai l'evento di sys_exit)
+func (a *ExecAuditor) Forget(pid uint32) {
+	for key := range a.pending {
+		if key.pid == pid {
+			delete(a.pending, key)
+		}
+	}
+	for key := range a.attempts {
+		if key.pid == pid {
+			delete(a.attempts, key)
+		}
+	}
+}
+
+// PrintSummary stampa i comandi lanciati raggruppati per funzione JS
+func (a *ExecAuditor) PrintSummary() {
+	if len(a.byFunction) == 0 {
+		return
+	}
+	fmt.Println("\n📊 Riepilogo processi lanciati per funzione JS:")
+
+	functions := make([]string, 0, len(a.byFunction))
+	for fn := range a.byFunction {
+		functions = append(functions, fn)
+	}
+	sort.Strings(functions)
+
+	for _, fn := range functions {
+		fmt.Printf("   %s\n", fn)
+		commands := make([]string, 0, len(a.byFunction[fn]))
+		for cmd := range a.byFunction[fn] {
+			commands = append(commands, cmd)
+		}
+		sort.Strings(commands)
+		for _, cmd := range commands {
+			fmt.Printf("      %4dx %s\n", a.byFunction[fn][cmd], cmd)
+		}
+	}
+	if a.failed > 0 {
+		fmt.Printf("   (%d tentativi di exec falliti non inclusi, es. ricerca del binario nel PATH)\n", a.failed)
+	}
+}
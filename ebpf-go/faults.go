@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+/*
+Page fault utente (--page-faults).
+Il kernel conta le page fault dei processi monitorati per (processo, stack, blocco di 256KB
+dell'indirizzo). All'uscita (o all'exec) di ogni processo, e alla fine per quelli
+ancora vivi, attribuiamo ogni fault alla prima funzione JavaScript dello stack
+(chi ha toccato la memoria) e classifichiamo l'indirizzo con le regioni di /proc/<PID>/maps:
+heap di V8, codice JIT, heap di malloc, librerie, memoria anonima.
+*/
+
+// Deve corrispondere a FAULT_BUCKET_SHIFT in trace.c
+const faultBucketShift = 18
+
+// Strutture gemelle di struct fault_key e struct fault_value in trace.c
+type faultKey struct {
+	Pid     uint32
+	StackId int32
+	Bucket  uint64
+}
+
+type faultValue struct {
+	Count  uint64
+	Writes uint64
+}
+
+// faultFunction sono i fault attribuiti a una funzione, divisi per regione di memoria
+type faultFunction struct {
+	name    string
+	count   uint64
+	writes  uint64
+	regions map[string]uint64
+}
+
+type FaultReport struct {
+	counts   *ebpf.Map
+	stackMap *ebpf.Map
+	targets  *TargetSet
+	top      int
+
+	// Fault già letti dal kernel, dei processi terminati o che hanno fatto exec
+	resolver     *stackResolver
+	functions    map[string]*faultFunction
+	regionTotals map[string]uint64
+	total        uint64
+	writes       uint64
+}
+
+func NewFaultReport(counts, stackMap *ebpf.Map, targets *TargetSet, top int) *FaultReport {
+	return &FaultReport{
+		counts:       counts,
+		stackMap:     stackMap,
+		targets:      targets,
+		top:          top,
+		resolver:     newStackResolver(stackMap, targets, nil),
+		functions:    make(map[string]*faultFunction),
+		regionTotals: make(map[string]uint64),
+	}
+}
+
+// classifyRegion descrive la regione di memoria che contiene addr.
+// L'heap di V8 è fatto di pagine anonime da 256KB allineate a 256KB: è un'euristica,
+// ma distingue bene le pagine di V8 dalle altre allocazioni anonime (thread stack, arena di malloc)
+func classifyRegion(symb *Symbolizer, addr uint64) string {
+	if symb.IsJIT(addr) {
+		return "codice JIT"
+	}
+	region, ok := symb.Region(addr)
+	if !ok {
+		return "sconosciuta"
+	}
+	const v8Page = 1 << faultBucketShift
+	switch {
+	case region.Path == "":
+		if strings.Contains(region.Perms, "x") {
+			return "codice JIT"
+		}
+		if region.Start%v8Page == 0 && (region.End-region.Start)%v8Page == 0 {
+			return "heap V8"
+		}
+		return "anonima"
+	case region.Path == "[heap]":
+		return "heap malloc"
+	case region.Path == "[stack]":
+		return "stack"
+	case strings.HasPrefix(region.Path, "["):
+		return region.Path // [vdso], [vvar]...
+	case strings.Contains(filepath.Base(region.Path), "libc.so"):
+		return "libc"
+	default:
+		return filepath.Base(region.Path)
+	}
+}
+
+// faultFunctionName sceglie a chi attribuire i fault di uno stack: la prima funzione JS,
+// altrimenti il frame più interno (es. un memcpy nativo chiamato da un addon)
+func faultFunctionName(frames []string) string {
+	for _, name := range frames {
+		if strings.HasPrefix(name, "[JS] ") {
+			return name
+		}
+	}
+	if len(frames) > 0 {
+		return frames[0]
+	}
+	return "(stack non disponibile)"
+}
+
+// CollectPid risolve e classifica subito i fault di un processo, finché ha ancora il suo
+// Symbolizer: va chiamato prima di dimenticarlo (uscita) o di sostituirlo (exec).
+// Le entry lette vengono tolte dalla mappa del kernel
+func (f *FaultReport) CollectPid(pid uint32) error {
+	err := f.collect(func(p uint32) bool { return p == pid })
+	f.resolver.ForgetPid(pid)
+	return err
+}
+
+// collect somma al report i fault dei processi scelti da match
+func (f *FaultReport) collect(match func(pid uint32) bool) error {
+	var (
+		key  faultKey
+		val  faultValue
+		done []faultKey
+	)
+	iter := f.counts.Iterate()
+	for iter.Next(&key, &val) {
+		if !match(key.Pid) {
+			continue
+		}
+		name := faultFunctionName(f.resolver.Frames(key.Pid, key.StackId, -1))
+		fn, ok := f.functions[name]
+		if !ok {
+			fn = &faultFunction{name: name, regions: make(map[string]uint64)}
+			f.functions[name] = fn
+		}
+		region := classifyRegion(f.targets.Symbolizer(key.Pid), key.Bucket<<faultBucketShift)
+		fn.count += val.Count
+		fn.writes += val.Writes
+		fn.regions[region] += val.Count
+		f.regionTotals[region] += val.Count
+		f.total += val.Count
+		f.writes += val.Writes
+		done = append(done, key)
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	for i := range done {
+		f.counts.Delete(&done[i])
+	}
+	return nil
+}
+
+// PrintSummary legge i contatori rimasti e stampa le funzioni con più page fault
+func (f *FaultReport) PrintSummary() error {
+	// Le regioni lette all'avvio non contengono le pagine allocate durante il monitoraggio
+	f.targets.ReloadProcMaps()
+	if err := f.collect(func(uint32) bool { return true }); err != nil {
+		return err
+	}
+	if f.total == 0 {
+		fmt.Println("\n📄 Nessuna page fault utente registrata")
+		return nil
+	}
+
+	fmt.Printf("\n📄 Page fault utente: %d (%d in scrittura) | %s\n", f.total, f.writes, formatRegionCounts(f.regionTotals))
+
+	list := make([]*faultFunction, 0, len(f.functions))
+	for _, fn := range f.functions {
+		list = append(list, fn)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].count != list[j].count {
+			return list[i].count > list[j].count
+		}
+		return list[i].name < list[j].name
+	})
+	if len(list) > f.top {
+		list = list[:f.top]
+	}
+	fmt.Println("📊 Funzioni con più page fault:")
+	for _, fn := range list {
+		fmt.Printf("   %5.1f%% %8d fault (%d in scrittura)  %s\n", float64(fn.count)/float64(f.total)*100, fn.count, fn.writes, fn.name)
+		fmt.Printf("            %s\n", formatRegionCounts(fn.regions))
+	}
+	return nil
+}
+
+// formatRegionCounts stampa i fault per regione, dalla più colpita, es. "heap V8 1200, libc 30"
+func formatRegionCounts(regions map[string]uint64) string {
+	names := make([]string, 0, len(regions))
+	for name := range regions {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if regions[names[i]] != regions[names[j]] {
+			return regions[names[i]] > regions[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s %d", name, regions[name])
+	}
+	return strings.Join(parts, ", ")
+}
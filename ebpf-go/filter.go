@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+/*
+Filtro sulle syscall.
+Le syscall scartate non arrivano mai in user space: il kernel le ignora in trace_sys_enter
+prima di catturare lo stack, così i flussi di epoll_wait, futex e clock_gettime non
+costano nulla. Si sceglie una lista di syscall da includere (--syscalls openat,connect)
+oppure da escludere (--exclude-syscalls epoll_wait,futex).
+*/
+
+// Modalità del filtro (enum syscall_filter_mode in trace.c)
+const (
+	filterNone  = 0
+	filterAllow = 1
+	filterDeny  = 2
+)
+
+// Dimensione di syscall_filter_map (MAX_SYSCALL_ID in trace.c)
+const maxSyscallId = 512
+
+// syscallId cerca l'id di una syscall a partire dal nome (es. "openat") o dal numero (es. "257")
+func syscallId(name string) (uint32, bool) {
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return uint32(id), true
+	}
+	for id, n := range syscallNames {
+		if n == name {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// parseSyscallList traduce una lista separata da virgole in id di syscall
+func parseSyscallList(list string) ([]uint32, error) {
+	var ids []uint32
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := syscallId(name)
+		if !ok {
+			return nil, fmt.Errorf("syscall sconosciuta: %q", name)
+		}
+		if id >= maxSyscallId {
+			return nil, fmt.Errorf("syscall %q fuori dalla tabella del filtro (id %d)", name, id)
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("lista di syscall vuota")
+	}
+	return ids, nil
+}
+
+// loadSyscallFilter segna nella mappa eBPF le syscall della lista
+func loadSyscallFilter(m *ebpf.Map, ids []uint32) error {
+	val := uint8(1)
+	for _, id := range ids {
+		key := id
+		if err := m.Put(&key, &val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// describeSyscallList restituisce i nomi delle syscall per il messaggio di avvio
+func describeSyscallList(ids []uint32) string {
+	names := make([]string, len(ids))
+	for i, id := range ids {
+		names[i] = getSyscallName(id)
+	}
+	return strings.Join(names, ", ")
+}
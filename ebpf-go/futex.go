@@ -0,0 +1,206 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+/*
+Modalità futex: contesa sui lock.
+Nel monitor delle syscall futex è solo rumore (--exclude-syscalls futex), ma ogni
+mutex o condition variable conteso tra i thread di V8, il threadpool di libuv e gli
+addon nativi passa da lì. Il kernel somma, per indirizzo della futex e per stack,
+quanto hanno aspettato i thread e chi li ha svegliati; qui classifichiamo i lock
+per tempo di attesa e mostriamo gli stack di chi aspetta e di chi sveglia.
+*/
+
+// Valori di enum futex_kind in trace.c
+const (
+	futexKindWait = 1
+	futexKindWake = 2
+)
+
+// Strutture gemelle di struct futex_key e struct futex_value in trace.c
+type futexKey struct {
+	Uaddr   uint64
+	Pid     uint32
+	Tid     uint32
+	StackId int32
+	Kind    uint32
+}
+
+type futexValue struct {
+	TotalNs uint64
+	MaxNs   uint64
+	Count   uint64
+	Woken   uint64
+}
+
+// futexLock raccoglie tutto quello che sappiamo di una futex (pid, indirizzo)
+type futexLock struct {
+	pid     uint32
+	uaddr   uint64
+	waitNs  uint64
+	maxNs   uint64
+	waits   uint64
+	wakes   uint64
+	woken   uint64
+	threads map[string]bool // Etichette dei thread che hanno aspettato
+	waiters *StackReport    // Valori in µs
+	wakers  *StackReport    // Valori in thread svegliati
+}
+
+func newFutexLock(pid uint32, uaddr uint64) *futexLock {
+	return &futexLock{
+		pid:     pid,
+		uaddr:   uaddr,
+		threads: make(map[string]bool),
+		waiters: NewStackReport(formatMicros),
+		wakers:  NewStackReport(func(v uint64) string { return fmt.Sprintf("%d risvegli", v) }),
+	}
+}
+
+func runFutex(args []string) {
+	fs := flag.NewFlagSet("futex", flag.ExitOnError)
+	var opts modeOptions
+	opts.register(fs)
+	stacks := fs.Int("stacks", 3, "quanti stack di attesa e di risveglio mostrare per ogni futex")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Uso corretto: sudo ./monitor futex [--duration 30s] [--top 20] [--stacks 3] [--folded out.folded] [--cgroup <percorso>]... [<PID_NODEJS>...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 && len(opts.cgroups) == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	pids := parsePIDs(fs.Args())
+
+	objs, targets := loadModeObjects(pids, opts.cgroups)
+	defer objs.Close()
+
+	tpEnter, err := link.Tracepoint("syscalls", "sys_enter_futex", objs.FutexEnter, nil)
+	if err != nil {
+		log.Fatalf("Errore aggancio sys_enter_futex: %v", err)
+	}
+	defer tpEnter.Close()
+	tpExit, err := link.Tracepoint("syscalls", "sys_exit_futex", objs.FutexExit, nil)
+	if err != nil {
+		log.Fatalf("Errore aggancio sys_exit_futex: %v", err)
+	}
+	defer tpExit.Close()
+
+	fmt.Printf("🔒 Analisi della contesa sulle futex di %s avviata. Ctrl+C per il report.\n", targets)
+	start := time.Now()
+	waitForStop(opts.duration)
+	elapsed := time.Since(start)
+
+	locks, folded, err := collectFutex(objs.FutexCounts, objs.ProfileStacks, targets)
+	if err != nil {
+		log.Fatalf("Errore lettura contatori: %v", err)
+	}
+
+	fmt.Printf("\n🔒 Contesa sulle futex in %s: %d indirizzi, %s di attesa complessiva\n",
+		elapsed.Round(time.Millisecond), len(locks), formatMicros(folded.Total()))
+	printFutexLocks(locks, opts.top, *stacks)
+	if opts.folded != "" {
+		if err := folded.WriteFolded(opts.folded); err != nil {
+			log.Fatalf("Errore scrittura %s: %v", opts.folded, err)
+		}
+		fmt.Printf("\n📝 Stack di attesa in formato folded (in µs, la futex è il frame più interno) scritti in %s\n", opts.folded)
+	}
+}
+
+// collectFutex legge i contatori e li raggruppa per futex. Restituisce anche un report
+// unico degli stack di attesa, con la futex come frame più interno, per il formato folded
+func collectFutex(counts, stackMap *ebpf.Map, targets *TargetSet) ([]*futexLock, *StackReport, error) {
+	resolver := newStackResolver(stackMap, targets, nil)
+	labels := NewThreadLabeler()
+	byAddr := make(map[[2]uint64]*futexLock)
+	folded := NewStackReport(formatMicros)
+
+	var (
+		key futexKey
+		val futexValue
+	)
+	iter := counts.Iterate()
+	for iter.Next(&key, &val) {
+		id := [2]uint64{uint64(key.Pid), key.Uaddr}
+		lock, ok := byAddr[id]
+		if !ok {
+			lock = newFutexLock(key.Pid, key.Uaddr)
+			byAddr[id] = lock
+		}
+
+		label := labels.Label(key.Pid, key.Tid, "?")
+		frames := resolver.Frames(key.Pid, key.StackId, -1)
+		switch key.Kind {
+		case futexKindWait:
+			lock.waitNs += val.TotalNs
+			lock.waits += val.Count
+			lock.maxNs = max(lock.maxNs, val.MaxNs)
+			lock.threads[label] = true
+			lock.waiters.Add(key.Pid, key.Tid, label, frames, val.TotalNs/1000)
+			leaf := fmt.Sprintf("futex 0x%x", key.Uaddr)
+			folded.Add(key.Pid, key.Tid, label, append([]string{leaf}, frames...), val.TotalNs/1000)
+		case futexKindWake:
+			lock.wakes += val.Count
+			lock.woken += val.Woken
+			lock.wakers.Add(key.Pid, key.Tid, label, frames, val.Woken)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	locks := make([]*futexLock, 0, len(byAddr))
+	for _, lock := range byAddr {
+		locks = append(locks, lock)
+	}
+	// Prima le futex con più attesa; a parità (es. solo risvegli) quelle svegliate più spesso
+	sort.Slice(locks, func(i, j int) bool {
+		if locks[i].waitNs != locks[j].waitNs {
+			return locks[i].waitNs > locks[j].waitNs
+		}
+		return locks[i].woken > locks[j].woken
+	})
+	return locks, folded, nil
+}
+
+// printFutexLocks stampa le n futex più contese con i loro stack di attesa e di risveglio
+func printFutexLocks(locks []*futexLock, n, stacks int) {
+	if len(locks) > n {
+		locks = locks[:n]
+	}
+	for i, lock := range locks {
+		fmt.Printf("\n🔒 #%-3d PID %d | futex 0x%x\n", i+1, lock.pid, lock.uaddr)
+		if lock.waits > 0 {
+			fmt.Printf("   ⏳ %d attese per %s (media %s, max %s) | thread: %s\n",
+				lock.waits, formatDuration(lock.waitNs), formatDuration(lock.waitNs/lock.waits),
+				formatDuration(lock.maxNs), sortedLabels(lock.threads))
+		}
+		if lock.wakes > 0 {
+			fmt.Printf("   🔔 %d risvegli, %d thread svegliati\n", lock.wakes, lock.woken)
+		}
+		lock.waiters.PrintTop("Stack di chi aspetta", stacks)
+		lock.wakers.PrintTop("Stack di chi sveglia", stacks)
+	}
+}
+
+// sortedLabels unisce le etichette dei thread in ordine alfabetico
+func sortedLabels(set map[string]bool) string {
+	labels := make([]string, 0, len(set))
+	for label := range set {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ", ")
+}
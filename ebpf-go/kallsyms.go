@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+Simboli del kernel.
+Gli stack del kernel (bpf_get_stackid senza BPF_F_USER_STACK) contengono indirizzi
+del kernel e dei suoi moduli: li traduciamo con /proc/kallsyms, che elenca
+l'indirizzo di partenza di ogni funzione.
+ES: ffffffff8139d2a0 T ext4_file_write_iter
+    ffffffffc0a41000 t nf_conntrack_in	[nf_conntrack]
+*/
+
+type kernelSymbol struct {
+	Addr   uint64
+	Name   string
+	Module string
+}
+
+// KernelSymbols contiene le funzioni del kernel ordinate per indirizzo
+type KernelSymbols struct {
+	symbols []kernelSymbol
+}
+
+// LoadKernelSymbols legge /proc/kallsyms. Da root gli indirizzi sono reali:
+// se sono tutti a zero (kptr_restrict) i simboli non servono a nulla e restituiamo un errore
+func LoadKernelSymbols() (*KernelSymbols, error) {
+	file, err := os.Open("/proc/kallsyms")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ks := &KernelSymbols{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		// Teniamo solo il codice: t/T (text), w/W (weak)
+		switch fields[1] {
+		case "t", "T", "w", "W":
+		default:
+			continue
+		}
+		addr, err := strconv.ParseUint(fields[0], 16, 64)
+		if err != nil || addr == 0 {
+			continue
+		}
+		sym := kernelSymbol{Addr: addr, Name: fields[2]}
+		if len(fields) >= 4 {
+			sym.Module = strings.Trim(fields[3], "[]")
+		}
+		ks.symbols = append(ks.symbols, sym)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ks.symbols) == 0 {
+		return nil, fmt.Errorf("/proc/kallsyms non contiene indirizzi (kptr_restrict?)")
+	}
+
+	sort.Slice(ks.symbols, func(i, j int) bool { return ks.symbols[i].Addr < ks.symbols[j].Addr })
+	return ks, nil
+}
+
+// lookup cerca la funzione che contiene ip
+func (ks *KernelSymbols) lookup(ip uint64) (kernelSymbol, bool) {
+	if ks == nil {
+		return kernelSymbol{}, false
+	}
+	// Primo simbolo che parte dopo ip: quello che lo contiene è il precedente
+	i := sort.Search(len(ks.symbols), func(i int) bool { return ks.symbols[i].Addr > ip })
+	if i == 0 {
+		return kernelSymbol{}, false
+	}
+	return ks.symbols[i-1], true
+}
+
+// Resolve traduce un indirizzo del kernel, es. "[K] ext4_file_write_iter+0x5a" oppure
+// "[K] nf_conntrack_in+0x12 [nf_conntrack]" per le funzioni dei moduli
+func (ks *KernelSymbols) Resolve(ip uint64) string {
+	sym, ok := ks.lookup(ip)
+	if !ok {
+		return fmt.Sprintf("[K] 0x%x", ip)
+	}
+	name := fmt.Sprintf("[K] %s+0x%x", sym.Name, ip-sym.Addr)
+	if sym.Module != "" {
+		name += " [" + sym.Module + "]"
+	}
+	return name
+}
+
+// Function restituisce solo il nome della funzione, senza offset (es. "[K] ext4_file_write_iter"):
+// serve per aggregare i campioni del profiler sulla stessa funzione
+func (ks *KernelSymbols) Function(ip uint64) string {
+	sym, ok := ks.lookup(ip)
+	if !ok {
+		return fmt.Sprintf("[K] 0x%x", ip)
+	}
+	return "[K] " + sym.Name
+}
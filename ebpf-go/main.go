@@ -1,11 +1,13 @@
 package main
 
-//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -target bpf trace trace.c
+//-target amd64 definisce __TARGET_ARCH_x86, necessario a PT_REGS_PARM* delle uprobe
+//go:generate go run github.com/cilium/ebpf/cmd/bpf2go -target amd64 trace trace.c
 
 import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -15,45 +17,234 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/cilium/ebpf"
 	"github.com/cilium/ebpf/link"
 	"github.com/cilium/ebpf/ringbuf"
 	"github.com/cilium/ebpf/rlimit"
 	"golang.org/x/sys/unix"
 )
 
-// Struttura gemella. Nota l'ordine: Timestamp per primo!
-// Essendo 8 + 4 + 4 byte = 16 byte precisi, non ci serve il padding ("_ uint32").
+// Valore gemello di struct config in trace.c (unica entry di config_map)
+type bpfConfig struct {
+	CgroupFilter  uint32
+	KernelStacks  uint32
+	SyscallFilter uint32
+	Aggregate     uint32
+	InlineStacks  uint32
+}
+
+// Tipi di record nel ring buffer (enum event_type in trace.c)
+const (
+	eventSyscall = 1
+	eventExec    = 2
+	eventProc    = 3
+	// Come eventSyscall, ma lo stack utente segue la SyscallInfo (struct inline_event)
+	eventSyscallInline = 4
+	// Generazione o consegna di un segnale fatale (struct signal_event)
+	eventSignal = 5
+	// Ingresso o ritorno di una funzione nativa scelta con --uprobe/--uretprobe (SyscallInfo)
+	eventUprobe = 6
+	// Il processo ha mappato nuovo codice (struct maps_event): solo il PID dopo il tipo
+	eventMaps = 7
+	// Esito di un exec annunciato con eventExec (struct exec_result)
+	eventExecResult = 8
+)
+
+// Struttura gemella. Nota l'ordine: prima il tipo del record!
+// Essendo 4 + 4 + 4 + 4 + 8 + 8 + 8 + 48 + 4 + 4 + 4 + 4 + 16 + 512 + 128 byte = 760 byte precisi, non ci serve il padding ("_ uint32").
 type SyscallInfo struct {
-	TimestampNs uint64
-	SyscallId   uint32
-	StackId     int32
+	Type          uint32
+	SyscallId     uint32
+	Pid           uint32
+	StackId       int32
+	TimestampNs   uint64
+	DurationNs    uint64
+	Ret           int64
+	Args          [6]uint64
+	AddrLen       uint32
+	Tid           uint32
+	KstackId      int32
+	KstackBlocked uint32
+	Comm          [16]byte
+	Paths         [2][256]byte
+	Addr          [128]byte
 }
 
-var syscallNames = map[uint32]string{
-	0: "read", 1: "write", 2: "open", 3: "close", 4: "stat", 5: "fstat",
-	9: "mmap", 10: "mprotect", 11: "munmap", 12: "brk", 14: "rt_sigprocmask",
-	16: "ioctl", 17: "pread64", 20: "writev", 21: "access", 22: "pipe",
-	24: "sched_yield", 28: "madvise", 41: "socket", 42: "connect", 44: "sendto", 228: "clock_gettime",
-	257: "openat", 262: "fstatat", 281: "epoll_wait", 293: "pipe2", 318: "getrandom",
+// Syscall che non ritornano: il kernel le invia senza valore di ritorno né durata
+var noReturnSyscalls = map[uint32]bool{60: true, 231: true}
+
+// Syscall che restituiscono un indirizzo di memoria, da stampare in esadecimale
+var addrReturnSyscalls = map[uint32]bool{9: true, 12: true}
+
+// formatRet traduce il valore di ritorno in una stringa leggibile.
+// Il kernel restituisce gli errori come -errno (tra -4095 e -1), es. -2 diventa -ENOENT
+func formatRet(id uint32, ret int64) string {
+	if ret < 0 && ret >= -4095 {
+		if name := unix.ErrnoName(syscall.Errno(-ret)); name != "" {
+			return "-" + name
+		}
+		return strconv.FormatInt(ret, 10)
+	}
+	if addrReturnSyscalls[id] {
+		return fmt.Sprintf("0x%x", uint64(ret))
+	}
+	return strconv.FormatInt(ret, 10)
 }
 
-func getSyscallName(id uint32) string {
-	if name, ok := syscallNames[id]; ok {
+// formatDuration stampa la durata in µs sotto il millisecondo (es. 12µs), altrimenti con la notazione di Go
+func formatDuration(ns uint64) string {
+	d := time.Duration(ns)
+	if d < time.Millisecond {
+		return fmt.Sprintf("%dµs", d/time.Microsecond)
+	}
+	return d.Round(time.Microsecond).String()
+}
+
+// formatSyscall produce la forma compatta "openat -> -ENOENT (12µs)"
+func formatSyscall(info SyscallInfo) string {
+	name := getSyscallName(info.SyscallId)
+	if noReturnSyscalls[info.SyscallId] {
 		return name
 	}
-	return fmt.Sprintf("syscall_%d", id)
+	return fmt.Sprintf("%s -> %s (%s)", name, formatRet(info.SyscallId, info.Ret), formatDuration(info.DurationNs))
+}
+
+// lookupStack recupera dalla StackMap gli indirizzi di uno stack, fermandosi al primo 0
+func lookupStack(stackMap *ebpf.Map, stackId int32) ([]uint64, error) {
+	if stackId < 0 {
+		return nil, fmt.Errorf("stack non catturato dal kernel (%d)", stackId)
+	}
+	var stackFrames [127]uint64
+	if err := stackMap.Lookup(&stackId, &stackFrames); err != nil {
+		return nil, err
+	}
+	for i, ip := range stackFrames {
+		if ip == 0 {
+			return stackFrames[:i], nil
+		}
+	}
+	return stackFrames[:], nil
 }
 
+// inlineStack estrae i frame che in un record eventSyscallInline seguono la SyscallInfo:
+// un contatore nr_frames (più 4 byte di padding) e poi gli indirizzi
+func inlineStack(raw []byte) ([]uint64, error) {
+	header := binary.Size(SyscallInfo{})
+	if len(raw) < header+8 {
+		return nil, fmt.Errorf("record inline troppo corto: %d byte", len(raw))
+	}
+	n := int(binary.LittleEndian.Uint32(raw[header:]))
+	data := raw[header+8:]
+	if n == 0 {
+		return nil, fmt.Errorf("stack non catturato dal kernel")
+	}
+	if n*8 > len(data) {
+		return nil, fmt.Errorf("record inline con %d frame ma solo %d byte", n, len(data))
+	}
+	frames := make([]uint64, n)
+	for i := range frames {
+		frames[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+	return frames, nil
+}
+
+// resolveFrames traduce gli indirizzi di uno stack nei nomi delle funzioni
+func resolveFrames(symb *Symbolizer, frames []uint64) []string {
+	names := make([]string, len(frames))
+	for i, ip := range frames {
+		names[i] = symb.Resolve(ip)
+	}
+	return names
+}
+
+// printStack stampa uno stack già risolto, un frame per riga
+func printStack(names []string) {
+	for i, name := range names {
+		fmt.Printf("      [%2d] %s\n", i, name)
+	}
+}
+
+// printStitchedStack stampa un unico stack kernel → nativo → JS: prima i frame del kernel
+// (il più interno in cima), poi quelli utente, con una numerazione continua
+func printStitchedStack(ksyms *KernelSymbols, kframes []uint64, names []string) {
+	for i, ip := range kframes {
+		fmt.Printf("      [%2d] %s\n", i, ksyms.Resolve(ip))
+	}
+	if len(kframes) > 0 {
+		fmt.Println("      ---- user space ----")
+	}
+	for i, name := range names {
+		fmt.Printf("      [%2d] %s\n", len(kframes)+i, name)
+	}
+}
+
+// stringList permette di ripetere un flag più volte (es. --cgroup a --cgroup b)
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ",") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
 func main() {
-	//os.Args array di stringhe passate in input, 0 è il nome del programma e 1 il PID
-	if len(os.Args) < 2 {
-		log.Fatalf("Uso corretto: sudo ./monitor <PID_NODEJS>")
+	//Le modalità di profiling sono sottocomandi (es. sudo ./monitor profile 1234)
+	if len(os.Args) > 1 {
+		if run, ok := modes[os.Args[1]]; ok {
+			run(os.Args[2:])
+			return
+		}
 	}
 
-	//conversione PID da stringa a intero
-	targetPID, err := strconv.ParseUint(os.Args[1], 10, 32)
-	if err != nil {
-		log.Fatalf("PID non valido: %v", err)
+	var cgroups stringList
+	flag.Var(&cgroups, "cgroup", "cgroup v2 da monitorare (ripetibile), es. system.slice/app.service")
+	kstack := flag.Bool("kstack", false, "cattura anche lo stack del kernel di ogni syscall")
+	onlySyscalls := flag.String("syscalls", "", "monitora solo queste syscall, es. openat,connect,execve")
+	excludeSyscalls := flag.String("exclude-syscalls", "", "ignora queste syscall, es. epoll_wait,futex,clock_gettime")
+	aggregate := flag.Bool("aggregate", false, "modalità aggregata: conta le coppie (syscall, stack) nel kernel invece di stampare ogni evento")
+	aggInterval := flag.Duration("interval", 10*time.Second, "con --aggregate, ogni quanto stampare la classifica")
+	aggTop := flag.Int("top", 10, "quanti stack mostrare nella classifica di --aggregate e quante funzioni nel report di --page-faults")
+	statsInterval := flag.Duration("stats-interval", 30*time.Second, "ogni quanto riportare eventi e stack persi")
+	inlineStacks := flag.Bool("inline-stacks", false, "copia lo stack utente dentro ogni evento invece di usare la StackMap")
+	lossWarn := flag.Float64("loss-warn", 1, "percentuale di eventi persi oltre la quale avvisare")
+	var uprobeSpecs, uretprobeSpecs stringList
+	flag.Var(&uprobeSpecs, "uprobe", "funzione nativa da tracciare (ripetibile), es. node:uv_fs_open o libssl.so.3:SSL_write")
+	flag.Var(&uretprobeSpecs, "uretprobe", "come --uprobe, ma l'evento arriva al ritorno con valore di ritorno e durata")
+	pageFaults := flag.Bool("page-faults", false, "conta anche le page fault utente, con il loro stack (report per funzione JS alla fine)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Uso corretto: sudo ./monitor [--cgroup <percorso>]... [--kstack] [--syscalls <lista> | --exclude-syscalls <lista>] [--aggregate [--interval 10s] [--top 10]] [--inline-stacks] [--uprobe|--uretprobe <binario:simbolo>]... [--page-faults] [--stats-interval 30s] [--loss-warn 1] [<PID_NODEJS>...]\n")
+		fmt.Fprintf(os.Stderr, "       sudo ./monitor profile [opzioni] [<PID_NODEJS>...]   (profiler CPU, vedi profile --help)\n")
+		fmt.Fprintf(os.Stderr, "       sudo ./monitor offcpu [opzioni] [<PID_NODEJS>...]    (tempo fuori CPU, vedi offcpu --help)\n")
+		fmt.Fprintf(os.Stderr, "       sudo ./monitor futex [opzioni] [<PID_NODEJS>...]     (contesa sui lock, vedi futex --help)\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	//Gli argomenti rimasti dopo i flag sono i PID da monitorare
+	if flag.NArg() == 0 && len(cgroups) == 0 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	targetPIDs := parsePIDs(flag.Args())
+
+	if *inlineStacks && *aggregate {
+		log.Fatalf("--inline-stacks e --aggregate non possono essere usati insieme: la modalità aggregata conta gli stack per id")
+	}
+
+	//Lista di syscall da includere o escludere, applicata direttamente nel kernel
+	if *onlySyscalls != "" && *excludeSyscalls != "" {
+		log.Fatalf("--syscalls e --exclude-syscalls non possono essere usati insieme")
+	}
+	filterMode := uint32(filterNone)
+	var filterIds []uint32
+	if *onlySyscalls != "" || *excludeSyscalls != "" {
+		list := *onlySyscalls
+		filterMode = filterAllow
+		if *excludeSyscalls != "" {
+			list = *excludeSyscalls
+			filterMode = filterDeny
+		}
+		var err error
+		if filterIds, err = parseSyscallList(list); err != nil {
+			log.Fatalf("Filtro syscall non valido: %v", err)
+		}
 	}
 
 	//Removes the limit on the amount of memory the current process can lock into RAM
@@ -69,10 +260,67 @@ func main() {
 	}
 	defer objs.Close()
 
-	//Inserisco nella mappa eBPF il target PID passato dall'utente
-	key := uint32(0)
-	val := uint32(targetPID)
-	objs.TargetPidMap.Put(&key, &val)
+	//Inserisco nelle mappe eBPF i PID e i cgroup passati dall'utente
+	targets := NewTargetSet(objs.TargetPidMap, objs.TargetCgroupMap)
+	for _, pid := range targetPIDs {
+		if err := targets.AddPid(pid); err != nil {
+			log.Fatalf("Errore inserimento PID %d: %v", pid, err)
+		}
+	}
+	for _, cg := range cgroups {
+		if err := targets.AddCgroup(cg); err != nil {
+			log.Fatalf("Errore inserimento cgroup: %v", err)
+		}
+	}
+
+	//Albero dei processi: i PID scelti sono le radici, i figli già vivi vengono aggiunti subito
+	//(quelli nati dopo l'avvio li aggiunge il kernel in trace_sched_fork)
+	procTree := NewProcessTree()
+	for _, pid := range targetPIDs {
+		procTree.AddRoot(pid)
+	}
+	for _, pid := range targetPIDs {
+		for _, child := range ExistingChildren(pid) {
+			ppid := parentPid(child)
+			if err := targets.AddChild(child, ppid); err != nil {
+				log.Printf("⚠️  Impossibile seguire il figlio %d: %v", child, err)
+				continue
+			}
+			procTree.AddChild(child, ppid, readComm(child))
+		}
+	}
+
+	cfg := bpfConfig{}
+	if targets.HasCgroups() {
+		cfg.CgroupFilter = 1
+	}
+	//In modalità aggregata nessuno leggerebbe (né libererebbe) gli stack del kernel
+	if *kstack && !*aggregate {
+		cfg.KernelStacks = 1
+	}
+	cfg.SyscallFilter = filterMode
+	if *aggregate {
+		cfg.Aggregate = 1
+	}
+	if *inlineStacks {
+		cfg.InlineStacks = 1
+	}
+	if err := loadSyscallFilter(objs.SyscallFilterMap, filterIds); err != nil {
+		log.Fatalf("Errore caricamento filtro syscall: %v", err)
+	}
+	cfgKey := uint32(0)
+	if err := objs.ConfigMap.Put(&cfgKey, &cfg); err != nil {
+		log.Fatalf("Errore scrittura configurazione: %v", err)
+	}
+
+	//Diciamo al kernel quali argomenti delle syscall contengono un percorso da copiare
+	if err := loadPathArgs(objs.PathArgsMap); err != nil {
+		log.Fatalf("Errore caricamento tabella percorsi: %v", err)
+	}
+	//...e quali contengono una sockaddr (connect, bind, sendto, accept)
+	if err := loadSockaddrArgs(objs.SockaddrArgsMap); err != nil {
+		log.Fatalf("Errore caricamento tabella indirizzi: %v", err)
+	}
 
 	//Aggagancia la funzione trace_sys_enter definita in trace.c a sysenter
 	tp, err := link.Tracepoint("raw_syscalls", "sys_enter", objs.TraceSysEnter, nil)
@@ -81,9 +329,94 @@ func main() {
 	}
 	defer tp.Close()
 
-	fmt.Printf("🔍 Monitoraggio stack trace per PID %d avviato (RING BUFFER).\n", targetPID)
+	//Aggancia trace_sys_exit a sysexit: è lui ad inviare l'evento completo di ritorno e durata
+	tpExit, err := link.Tracepoint("raw_syscalls", "sys_exit", objs.TraceSysExit, nil)
+	if err != nil {
+		log.Fatalf("Errore aggancio tracepoint: %v", err)
+	}
+	defer tpExit.Close()
+
+	//Agganciamo il ciclo di vita dei processi, per seguire automaticamente i figli
+	schedProgs := []*ebpf.Program{objs.TraceSchedFork, objs.TraceSchedExec, objs.TraceSchedExit}
+	for _, prog := range schedProgs {
+		l, err := link.AttachTracing(link.TracingOptions{Program: prog})
+		if err != nil {
+			log.Fatalf("Errore aggancio tracepoint sched: %v", err)
+		}
+		defer l.Close()
+	}
+
+	//Segnali che possono uccidere i target: mittente alla generazione, stack alla consegna
+	signalProgs := []*ebpf.Program{objs.TraceSignalGenerate, objs.TraceSignalDeliver}
+	for _, prog := range signalProgs {
+		l, err := link.AttachTracing(link.TracingOptions{Program: prog})
+		if err != nil {
+			log.Fatalf("Errore aggancio tracepoint dei segnali: %v", err)
+		}
+		defer l.Close()
+	}
 
-	symb := NewSymbolizer(int(targetPID))
+	//Con --kstack seguiamo anche i cambi di contesto, per catturare lo stack del kernel
+	//nel punto in cui la syscall si blocca (disco, rete, lock...)
+	var ksyms *KernelSymbols
+	if *kstack && *aggregate {
+		log.Printf("⚠️  --kstack non ha effetto in modalità aggregata: gli stack del kernel non fanno parte della chiave")
+	}
+	if *kstack && !*aggregate {
+		l, err := link.AttachTracing(link.TracingOptions{Program: objs.TraceSchedSwitch})
+		if err != nil {
+			log.Fatalf("Errore aggancio sched_switch: %v", err)
+		}
+		defer l.Close()
+
+		ksyms, err = LoadKernelSymbols()
+		if err != nil {
+			log.Printf("⚠️  Simboli del kernel non disponibili: %v", err)
+		}
+	}
+
+	//Con --page-faults contiamo anche le page fault utente: non passano dal ring buffer,
+	//il report arriva alla fine
+	var faultReport *FaultReport
+	if *pageFaults {
+		l, err := link.Tracepoint("exceptions", "page_fault_user", objs.TracePageFault, nil)
+		if err != nil {
+			log.Fatalf("Errore aggancio page_fault_user: %v", err)
+		}
+		defer l.Close()
+		faultReport = NewFaultReport(objs.FaultCounts, objs.ProfileStacks, targets, *aggTop)
+	}
+
+	fmt.Printf("🔍 Monitoraggio stack trace per %s avviato (RING BUFFER).\n", targets)
+	switch filterMode {
+	case filterAllow:
+		fmt.Printf("🎚️  Solo le syscall: %s\n", describeSyscallList(filterIds))
+	case filterDeny:
+		fmt.Printf("🎚️  Syscall ignorate: %s\n", describeSyscallList(filterIds))
+	}
+
+	//Prepariamo subito i Symbolizer dei PID noti, quelli dei cgroup nascono al primo evento
+	for _, pid := range targetPIDs {
+		targets.Symbolizer(pid)
+	}
+
+	//Uprobe sulle funzioni native scelte dall'utente: i binari indicati per nome si cercano
+	//tra quelli caricati dai processi, quindi dopo aver preparato i Symbolizer
+	uprobes, err := AttachUprobes(objs.UprobeEntry, objs.UprobeReturn, uprobeSpecs, uretprobeSpecs, targetPIDs, targets)
+	if err != nil {
+		log.Fatalf("Errore aggancio uprobe: %v", err)
+	}
+	defer uprobes.Close()
+	for _, probe := range uprobes.probes {
+		fmt.Printf("🪝 Uprobe: %s\n", probe.Describe())
+	}
+
+	//Leggiamo da tracefs il formato degli argomenti di ogni syscall
+	//Se non è disponibile continuiamo comunque, stampando gli argomenti grezzi
+	schemas, err := LoadSyscallSchemas()
+	if err != nil {
+		log.Printf("⚠️  Argomenti non decodificabili: %v", err)
+	}
 
 	var ts unix.Timespec
 	//Riempe ts con i secondi ed i nanosecondi da quando la macchina è accesa
@@ -115,9 +448,32 @@ func main() {
 		<-stopper
 		fmt.Println("\n🛑 Uscita in corso...")
 		rd.Close() // Chiudendo il reader sblocchiamo il for sottostante
-		os.Exit(0)
 	}()
 
+	//Raccoglie i comandi lanciati dal processo Node, per il riepilogo finale
+	execAudit := NewExecAuditor()
+	//Etichette dei thread (main, libuv-worker-N, V8 DefaultWorker...) e riepilogo per thread
+	threadLabels := NewThreadLabeler()
+	threadStats := NewThreadStats()
+	//Segnali fatali e autopsia dei processi terminati da un segnale
+	signals := NewSignalTracker()
+
+	//In modalità aggregata le syscall non passano dal ring buffer (che porta solo exec e processi):
+	//la classifica viene stampata quando scade la deadline di lettura
+	//Stack già risolti e pulizia della StackMap
+	stacks := NewStackTable(objs.StackMap, objs.StackRefs)
+
+	var aggregator *Aggregator
+	nextDrain := time.Now().Add(*aggInterval)
+	if *aggregate {
+		aggregator = NewAggregator(objs.AggMap, stacks, targets, threadLabels, *aggTop)
+		fmt.Printf("📈 Modalità aggregata: classifica degli stack ogni %s\n", *aggInterval)
+	}
+
+	//Contatori delle perdite, nel kernel (stats_map) e in user space
+	lossStats := NewLossStats(objs.StatsMap, *lossWarn)
+	nextStats := time.Now().Add(*statsInterval)
+
 	fmt.Println("In attesa di eventi...")
 
 	//creiamo un punto di partenza per la lettura del file perf-map
@@ -127,62 +483,280 @@ func main() {
 	for {
 		// Il programma si "addormenta" qui finché il kernel non invia un evento
 		//ogni volta che arriva un evento nel buffer, viene messo in record
+		//Il lavoro periodico (classifica aggregata, report delle perdite) va fatto anche
+		//se non arrivano eventi: la lettura si sblocca alla prima scadenza
+		now := time.Now()
+		if aggregator != nil && !now.Before(nextDrain) {
+			interval, err := aggregator.Drain()
+			if err != nil {
+				log.Printf("Errore lettura contatori: %v", err)
+			}
+			aggregator.PrintTop(fmt.Sprintf("Top syscall stack (ultimi %s)", *aggInterval), interval)
+			nextDrain = now.Add(*aggInterval)
+			//Nessun record arriva dal ring buffer per le syscall contate: gli stack rilasciati
+			//dallo svuotamento si liberano qui
+			if err := stacks.Sweep(); err != nil {
+				log.Printf("Errore pulizia StackMap: %v", err)
+			}
+		}
+		if !now.Before(nextStats) {
+			if err := lossStats.Report(); err != nil {
+				log.Printf("Errore lettura statistiche: %v", err)
+			}
+			nextStats = now.Add(*statsInterval)
+		}
+		deadline := nextStats
+		if aggregator != nil && nextDrain.Before(deadline) {
+			deadline = nextDrain
+		}
+		rd.SetDeadline(deadline)
+
 		record, err := rd.Read()
 		if err != nil {
-			// Se l'errore è dovuto alla chiusura del file (da parte di Ctrl+C), usciamo in silenzio
+			// Deadline scaduta: è il momento del lavoro periodico
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				continue
+			}
+			// Se l'errore è dovuto alla chiusura del file (da parte di Ctrl+C), usciamo dal ciclo
 			if errors.Is(err, ringbuf.ErrClosed) || errors.Is(err, os.ErrClosed) || strings.Contains(err.Error(), "file already closed") {
-				return
+				break
 			}
 			log.Printf("Errore lettura ringbuf: %v", err)
 			continue
 		}
 
-		// Ricarichiamo le mappe JIT (perf-map) al massimo una volta ogni 5 secondi,
-		// per evitare rallentamenti se arrivano migliaia di eventi in un secondo.
-
-		//Se sono passati meno di 5 secondi, salta il blocco e usa la mappa in memoria
-		//Se sono passati più di 5 secondi, rilegge il file perf-map
-		// per aggiornarsi sulle nuove funzioni JIT caricate da Node.js,
-		//  e poi aggiorna lastJITReload all'ora attuale
+		// Ogni 5 secondi leggiamo le righe aggiunte alle perf-map, per avere le nuove
+		// funzioni JIT anche dei processi che non compaiono negli stack.
+		// Le funzioni compilate nel frattempo le legge il Symbolizer stesso, quando
+		// un indirizzo del codice JIT non corrisponde a nessuna funzione conosciuta
 		if time.Since(lastJITReload) > 5*time.Second {
-			symb.loadPerfMap()
+			targets.UpdatePerfMaps()
 			lastJITReload = time.Now()
-		}
+			//Con --page-faults le regioni servono a classificare gli indirizzi: a processo
+			//terminato /proc/<PID>/maps non si legge più, teniamo le ultime lette
+			if faultReport != nil {
+				targets.ReloadProcMaps()
+			}
 
-		// 3. DECODIFICA BINARIA
-		// Trasformiamo i 16 byte grezzi (record.RawSample) nella nostra Go SyscallInfo
-		var info SyscallInfo
-		//Read taglia i byte letti in 8+4+4 e li assegna alla struct info che abbiamo definito
-		if err := binary.Read(bytes.NewBuffer(record.RawSample), binary.LittleEndian, &info); err != nil {
-			log.Printf("Errore decodifica evento: %v", err)
-			continue
+			//Nello stesso momento liberiamo gli stack che nessun evento usa più
+			if err := stacks.Sweep(); err != nil {
+				log.Printf("Errore pulizia StackMap: %v", err)
+			}
 		}
 
-		// Andiamo a ripescare i dettagli dello stack tramite lo stack id (nella mappa StackMap)
-		var stackFrames [127]uint64
-		err = objs.StackMap.Lookup(&info.StackId, &stackFrames)
-		if err != nil {
+		// Il primo campo (4 byte) di ogni record ci dice quale struttura contiene
+		if len(record.RawSample) < 4 {
+			log.Printf("Record troppo corto: %d byte", len(record.RawSample))
+			lossStats.Count(userShortRecords)
 			continue
 		}
+		switch eventType := binary.LittleEndian.Uint32(record.RawSample[:4]); eventType {
+		case eventSyscall, eventSyscallInline, eventUprobe:
+			// 3. DECODIFICA BINARIA
+			// Trasformiamo i 760 byte grezzi (record.RawSample) nella nostra Go SyscallInfo
+			var info SyscallInfo
+			//Read taglia i byte letti in 4+4+4+4+8+8+8+48+4+4+4+4+16+512+128 e li assegna alla struct info che abbiamo definito
+			if err := binary.Read(bytes.NewBuffer(record.RawSample), binary.LittleEndian, &info); err != nil {
+				log.Printf("Errore decodifica evento: %v", err)
+				lossStats.Count(userDecodeErrors)
+				continue
+			}
+
+			// Andiamo a ripescare i dettagli dello stack tramite lo stack id (nella mappa StackMap)
+			// oppure, con --inline-stacks, li leggiamo direttamente dal record.
+			// Senza stack l'evento viene stampato comunque: la syscall è avvenuta
+			//CONVERTIAMO GLI INDIRIZZI DI MEMORIA NEI NOMI DELLE FUNZIONI
+			//per ogni elemento dello stack estraggo l'indirizzo ip instruction pointer
+			//e risolvo il simbolo ip con symbolizer (una sola volta per stack, grazie alla cache)
+			symb := targets.Symbolizer(info.Pid)
+			var stackNames []string
+			var stackErr error
+			stackDesc := fmt.Sprintf("Stack ID: %d", info.StackId)
+			if eventType == eventSyscallInline {
+				var frames []uint64
+				frames, stackErr = inlineStack(record.RawSample)
+				stackNames = resolveFrames(symb, frames)
+				stackDesc = fmt.Sprintf("Stack: inline, %d frame", len(frames))
+			} else {
+				stackNames, stackErr = stacks.Resolve(info.Pid, info.StackId, symb)
+				if stackErr != nil && info.StackId >= 0 {
+					lossStats.Count(userStackLookups) // Gli id negativi li ha già contati il kernel
+				}
+			}
 
-		//Ricavo data ed ora esatta in cui si è verificato l'evento
-		//aggiungendo al tempo di boot i nanosecondi in cui si è verificato l'evento
-		eventTime := bootTime.Add(time.Duration(info.TimestampNs))
-		timeStr := eventTime.Format("15:04:05.000000")
+			//Ricavo data ed ora esatta in cui si è verificato l'evento
+			//aggiungendo al tempo di boot i nanosecondi in cui si è verificato l'evento
+			eventTime := bootTime.Add(time.Duration(info.TimestampNs))
+			timeStr := eventTime.Format("15:04:05.000000")
 
-		fmt.Printf("\n🕒 [%s] 🔹 Syscall: %-15s (ID: %d) | Stack ID: %d\n",
-			timeStr, getSyscallName(info.SyscallId), info.SyscallId, info.StackId)
+			label := threadLabels.Label(info.Pid, info.Tid, cString(info.Comm[:]))
 
-		//CONVERTIAMO GLI INDIRIZZI DI MEMORIA NEI NOMI DELLE FUNZIONI
-		//per ogni elemento di stackFrames estraggo indice i ed indirizzo ip instruction pointer
-		//Se incontro un ip = 0x00000000 , lo stack è finito (< 127 frame) e quindi chiudo
-		//poi risolvo il simbolo ip con symbolizer
-		for i, ip := range stackFrames {
-			if ip == 0 {
+			//Le uprobe hanno solo stack e registri: niente statistiche per syscall, percorsi o stack del kernel
+			if eventType == eventUprobe {
+				fmt.Printf("\n🕒 [%s] 🪝 PID %d | TID %d [%s] | Uprobe: %s | %s\n",
+					timeStr, info.Pid, info.Tid, label, uprobes.Format(info), stackDesc)
+				if stackErr != nil {
+					fmt.Printf("      ⚠️  Stack non disponibile: %v\n", stackErr)
+				}
+				fmt.Printf("      📋 Argomenti: %s\n", formatUprobeArgs(info))
+				printStack(stackNames)
+				stacks.Release(info.StackId)
 				break
 			}
-			funcName := symb.Resolve(ip)
-			fmt.Printf("      [%2d] %s\n", i, funcName)
+			threadStats.Add(info.Pid, info.Tid, label, info)
+
+			fmt.Printf("\n🕒 [%s] 🔹 PID %d | TID %d [%s] | Syscall: %-35s (ID: %d) | %s\n",
+				timeStr, info.Pid, info.Tid, label, formatSyscall(info), info.SyscallId, stackDesc)
+			if stackErr != nil {
+				fmt.Printf("      ⚠️  Stack non disponibile: %v\n", stackErr)
+			}
+			fmt.Printf("      📋 Argomenti: %s\n", schemas.FormatArgs(info.SyscallId, info.Args))
+			paths := formatPaths(info.Pid, info)
+			if info.SyscallId == sysExecve || info.SyscallId == sysExecveat {
+				paths = execAudit.TakeBinary(info.Pid, info.Tid)
+			}
+			if paths != "" {
+				fmt.Printf("      📂 File: %s\n", paths)
+			}
+			if addr := formatSockaddr(info); addr != "" {
+				fmt.Printf("      🌐 Rete: %s\n", addr)
+			}
+
+			if !*kstack {
+				printStack(stackNames)
+				stacks.Release(info.StackId)
+				break
+			}
+
+			//Con --kstack anteponiamo lo stack del kernel: quello del punto di attesa se il thread
+			//si è bloccato, altrimenti quello di ingresso nella syscall
+			kernelFrames, err := stacks.Frames(info.KstackId)
+			if err != nil && info.KstackId >= 0 {
+				lossStats.Count(userStackLookups)
+			}
+			if err != nil {
+				fmt.Printf("      🐧 Stack del kernel non disponibile: %v\n", err)
+			} else if info.KstackBlocked != 0 {
+				fmt.Printf("      🐧 Stack del kernel (Stack ID: %d) nel punto di attesa:\n", info.KstackId)
+			} else {
+				fmt.Printf("      🐧 Stack del kernel (Stack ID: %d) all'ingresso della syscall:\n", info.KstackId)
+			}
+			printStitchedStack(ksyms, kernelFrames, stackNames)
+			//L'evento è stampato: i suoi stack possono essere liberati quando nessun altro li usa
+			stacks.Release(info.StackId)
+			stacks.Release(info.KstackId)
+
+		case eventExec:
+			var ev ExecEvent
+			if err := binary.Read(bytes.NewBuffer(record.RawSample), binary.LittleEndian, &ev); err != nil {
+				log.Printf("Errore decodifica exec: %v", err)
+				lossStats.Count(userDecodeErrors)
+				continue
+			}
+			// Lo stack del figlio è una copia della memoria del padre al momento della fork:
+			// se il padre è monitorato, gli indirizzi JIT si risolvono con il suo Symbolizer
+			symbPid := ev.Pid
+			if targets.IsTraced(ev.Ppid) {
+				symbPid = ev.Ppid
+			}
+			stackFrames, err := stacks.Frames(ev.StackId)
+			stacks.Release(ev.StackId)
+			if err != nil {
+				if ev.StackId >= 0 {
+					lossStats.Count(userStackLookups)
+				}
+				log.Printf("⚠️  Stack dell'exec non disponibile: %v", err)
+			}
+			eventTime := bootTime.Add(time.Duration(ev.TimestampNs))
+			execAudit.Handle(ev, eventTime, targets.Symbolizer(symbPid), stackFrames)
+
+		case eventExecResult:
+			var res ExecResult
+			if err := binary.Read(bytes.NewBuffer(record.RawSample), binary.LittleEndian, &res); err != nil {
+				log.Printf("Errore decodifica esito exec: %v", err)
+				lossStats.Count(userDecodeErrors)
+				continue
+			}
+			execAudit.HandleResult(res)
+
+		case eventProc:
+			var ev ProcEvent
+			if err := binary.Read(bytes.NewBuffer(record.RawSample), binary.LittleEndian, &ev); err != nil {
+				log.Printf("Errore decodifica evento processo: %v", err)
+				lossStats.Count(userDecodeErrors)
+				continue
+			}
+			// I fault del processo vanno risolti finché il suo Symbolizer esiste ancora:
+			// all'uscita e dopo un exec procTree.Handle lo scarta
+			if faultReport != nil && (ev.Kind == procExit || ev.Kind == procExec) {
+				if err := faultReport.CollectPid(ev.Pid); err != nil {
+					log.Printf("Errore lettura page fault: %v", err)
+				}
+			}
+			procTree.Handle(ev, bootTime.Add(time.Duration(ev.TimestampNs)), targets)
+			if ev.Kind == procExit {
+				signals.HandleExit(ev)
+				execAudit.Forget(ev.Pid)
+			}
+			// Dopo un exec i thread sono nuovi, dopo l'uscita non esistono più
+			if ev.Kind == procExec || ev.Kind == procExit {
+				threadLabels.Forget(ev.Pid)
+				stacks.ForgetPid(ev.Pid)
+			}
+			// Senza cgroup non possono arrivare nuovi target: se sono usciti tutti abbiamo finito
+			if ev.Kind == procExit && targets.AllExited() {
+				fmt.Println("\n🏁 Tutti i processi monitorati sono terminati")
+				rd.Close()
+			}
+
+		case eventMaps:
+			if len(record.RawSample) < 8 {
+				lossStats.Count(userShortRecords)
+				continue
+			}
+			targets.MarkMapsDirty(binary.LittleEndian.Uint32(record.RawSample[4:8]))
+
+		case eventSignal:
+			var ev SignalEvent
+			if err := binary.Read(bytes.NewBuffer(record.RawSample), binary.LittleEndian, &ev); err != nil {
+				log.Printf("Errore decodifica segnale: %v", err)
+				lossStats.Count(userDecodeErrors)
+				continue
+			}
+			signals.Handle(ev, bootTime.Add(time.Duration(ev.TimestampNs)), targets.Symbolizer(ev.Pid), threadLabels)
+
+		default:
+			log.Printf("Tipo di record sconosciuto: %d", eventType)
+			lossStats.Count(userUnknownRecords)
+		}
+	}
+
+	if aggregator != nil {
+		// Ultimi contatori rimasti nel kernel, poi la classifica complessiva
+		if _, err := aggregator.Drain(); err != nil {
+			log.Printf("Errore lettura contatori: %v", err)
+		}
+		aggregator.PrintSummary()
+	}
+
+	procTree.Print()
+	threadStats.PrintSummary()
+
+	// Riepilogo finale dei comandi lanciati, raggruppati per funzione JS
+	execAudit.PrintSummary()
+
+	if faultReport != nil {
+		if err := faultReport.PrintSummary(); err != nil {
+			log.Printf("Errore lettura page fault: %v", err)
 		}
 	}
+
+	if n := stacks.Reclaimed(); n > 0 {
+		fmt.Printf("\n♻️  %d stack liberati dalla StackMap durante il monitoraggio\n", n)
+	}
+
+	// Bilancio di quanto è andato perso, anche solo per dire che non si è perso nulla
+	if err := lossStats.PrintSummary(); err != nil {
+		log.Printf("Errore lettura statistiche: %v", err)
+	}
 }
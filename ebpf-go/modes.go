@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/cilium/ebpf/rlimit"
+)
+
+/*
+Sottocomandi del monitor.
+Senza sottocomando il monitor traccia le syscall una per una; i sottocomandi sono modalità
+di profiling che non passano dal ring buffer ma contano gli stack nel kernel e stampano
+un report alla fine (es. sudo ./monitor profile --freq 99 1234).
+*/
+
+// modes associa il nome del sottocomando alla funzione che lo esegue
+var modes = map[string]func(args []string){
+	"profile": runProfile,
+	"offcpu":  runOffCPU,
+	"futex":   runFutex,
+}
+
+// modeOptions sono i flag comuni a tutte le modalità di profiling
+type modeOptions struct {
+	cgroups  stringList
+	duration time.Duration
+	top      int
+	folded   string
+}
+
+func (o *modeOptions) register(fs *flag.FlagSet) {
+	fs.Var(&o.cgroups, "cgroup", "cgroup v2 da monitorare (ripetibile), es. system.slice/app.service")
+	fs.DurationVar(&o.duration, "duration", 0, "durata della raccolta (0 = fino a Ctrl+C)")
+	fs.IntVar(&o.top, "top", 20, "quanti stack e funzioni mostrare nel report")
+	fs.StringVar(&o.folded, "folded", "", "file in cui scrivere gli stack in formato folded (flamegraph.pl, speedscope)")
+}
+
+// parsePIDs converte gli argomenti rimasti dopo i flag nei PID da monitorare
+func parsePIDs(args []string) []uint32 {
+	var pids []uint32
+	for _, arg := range args {
+		//conversione PID da stringa a intero
+		pid, err := strconv.ParseUint(arg, 10, 32)
+		if err != nil {
+			log.Fatalf("PID non valido: %v", err)
+		}
+		pids = append(pids, uint32(pid))
+	}
+	return pids
+}
+
+// loadModeObjects carica il programma eBPF e riempie le mappe dei target (PID, figli già
+// vivi, cgroup) come fa il monitor delle syscall. Il chiamante deve chiudere objs
+func loadModeObjects(pids []uint32, cgroups []string) (*traceObjects, *TargetSet) {
+	if err := rlimit.RemoveMemlock(); err != nil {
+		log.Fatal(err)
+	}
+
+	objs := &traceObjects{}
+	if err := loadTraceObjects(objs, nil); err != nil {
+		log.Fatalf("Errore caricamento oggetti: %v", err)
+	}
+
+	targets := NewTargetSet(objs.TargetPidMap, objs.TargetCgroupMap)
+	for _, pid := range pids {
+		if err := targets.AddPid(pid); err != nil {
+			log.Fatalf("Errore inserimento PID %d: %v", pid, err)
+		}
+		for _, child := range ExistingChildren(pid) {
+			if err := targets.AddChild(child, parentPid(child)); err != nil {
+				log.Printf("⚠️  Impossibile seguire il figlio %d: %v", child, err)
+			}
+		}
+	}
+	for _, cg := range cgroups {
+		if err := targets.AddCgroup(cg); err != nil {
+			log.Fatalf("Errore inserimento cgroup: %v", err)
+		}
+	}
+
+	cfg := bpfConfig{}
+	if targets.HasCgroups() {
+		cfg.CgroupFilter = 1
+	}
+	cfgKey := uint32(0)
+	if err := objs.ConfigMap.Put(&cfgKey, &cfg); err != nil {
+		log.Fatalf("Errore scrittura configurazione: %v", err)
+	}
+	return objs, targets
+}
+
+// waitForStop aspetta Ctrl+C, SIGTERM o la fine della durata richiesta
+func waitForStop(duration time.Duration) {
+	waitForStopTicking(duration, 0, nil)
+}
+
+// waitForStopTicking è come waitForStop, ma nel frattempo chiama tick ogni interval
+// (es. per leggere le mappe dei processi prima che terminino)
+func waitForStopTicking(duration, interval time.Duration, tick func()) {
+	stopper := make(chan os.Signal, 1)
+	signal.Notify(stopper, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stopper)
+
+	var timeout <-chan time.Time
+	if duration > 0 {
+		timeout = time.After(duration)
+	}
+	var ticks <-chan time.Time
+	if tick != nil {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	for {
+		select {
+		case <-stopper:
+			fmt.Println("\n🛑 Uscita in corso...")
+			return
+		case <-timeout:
+			return
+		case <-ticks:
+			tick()
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+/*
+Modalità offcpu: dove stanno fermi i thread.
+Il profiler CPU vede solo chi lavora; qui guardiamo il contrario: quanto tempo il main
+thread e i worker di libuv passano fuori dalla CPU, e con quale stack JS ci sono finiti
+(disco, lock, rete, oppure l'event loop inattivo in epoll_wait). Il tempo è diviso in
+bloccato (il thread dormiva) e runqueue (era pronto ma la CPU era occupata da altri).
+*/
+
+// Strutture gemelle di struct offcpu_key e struct offcpu_value in trace.c
+type offcpuKey struct {
+	Pid      uint32
+	Tid      uint32
+	StackId  int32
+	KstackId int32
+}
+
+type offcpuValue struct {
+	BlockedNs  uint64
+	RunqueueNs uint64
+	Count      uint64
+}
+
+// offcpuThread è il totale di un thread, per il riepilogo
+type offcpuThread struct {
+	label    string
+	blocked  uint64
+	runqueue uint64
+	count    uint64
+}
+
+func runOffCPU(args []string) {
+	fs := flag.NewFlagSet("offcpu", flag.ExitOnError)
+	var opts modeOptions
+	opts.register(fs)
+	minBlock := fs.Duration("min", 0, "ignora gli stack con meno tempo fuori CPU di questo valore")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Uso corretto: sudo ./monitor offcpu [--duration 30s] [--top 20] [--min 1ms] [--folded out.folded] [--cgroup <percorso>]... [<PID_NODEJS>...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 && len(opts.cgroups) == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	pids := parsePIDs(fs.Args())
+
+	objs, targets := loadModeObjects(pids, opts.cgroups)
+	defer objs.Close()
+
+	for _, prog := range []*ebpf.Program{objs.OffcpuSwitch, objs.OffcpuWakeup} {
+		l, err := link.AttachTracing(link.TracingOptions{Program: prog})
+		if err != nil {
+			log.Fatalf("Errore aggancio tracepoint sched: %v", err)
+		}
+		defer l.Close()
+	}
+
+	fmt.Printf("💤 Profiling off-CPU di %s avviato. Ctrl+C per il report.\n", targets)
+	start := time.Now()
+	waitForStop(opts.duration)
+	elapsed := time.Since(start)
+
+	ksyms, err := LoadKernelSymbols()
+	if err != nil {
+		log.Printf("⚠️  Simboli del kernel non disponibili: %v", err)
+	}
+	blocked, runqueue, threads, err := collectOffCPU(objs.OffcpuCounts, objs.ProfileStacks, targets, ksyms, uint64(*minBlock))
+	if err != nil {
+		log.Fatalf("Errore lettura contatori: %v", err)
+	}
+
+	fmt.Printf("\n💤 Profilo off-CPU in %s: %s bloccati, %s in runqueue\n", elapsed.Round(time.Millisecond),
+		formatMicros(blocked.Total()), formatMicros(runqueue.Total()))
+	printOffCPUThreads(threads)
+	blocked.PrintTopFunctions("Funzioni con più tempo bloccato (primo frame utente)", opts.top)
+	blocked.PrintTop("Stack con più tempo bloccato", opts.top)
+	runqueue.PrintTop("Stack con più attesa in runqueue", opts.top)
+	if opts.folded != "" {
+		if err := blocked.WriteFolded(opts.folded); err != nil {
+			log.Fatalf("Errore scrittura %s: %v", opts.folded, err)
+		}
+		fmt.Printf("\n📝 Stack bloccati in formato folded (in µs) scritti in %s\n", opts.folded)
+	}
+	// Tempo fuori CPU che non compare nel report
+	if err := NewLossStats(objs.StatsMap, 0).PrintKernelLosses(statThreadFull, statAggFull); err != nil {
+		log.Printf("Errore lettura statistiche: %v", err)
+	}
+}
+
+// collectOffCPU legge i contatori e prepara due report, tempo bloccato e tempo in runqueue,
+// più i totali per thread
+func collectOffCPU(counts, stackMap *ebpf.Map, targets *TargetSet, ksyms *KernelSymbols, minNs uint64) (blocked, runqueue *StackReport, threads map[threadKey]*offcpuThread, err error) {
+	blocked = NewStackReport(formatMicros)
+	blocked.userSelf = true
+	runqueue = NewStackReport(formatMicros)
+	runqueue.userSelf = true
+	threads = make(map[threadKey]*offcpuThread)
+
+	resolver := newStackResolver(stackMap, targets, ksyms)
+	labels := NewThreadLabeler()
+
+	var (
+		key offcpuKey
+		val offcpuValue
+	)
+	iter := counts.Iterate()
+	for iter.Next(&key, &val) {
+		label := labels.Label(key.Pid, key.Tid, "?")
+		tk := threadKey{key.Pid, key.Tid}
+		t, ok := threads[tk]
+		if !ok {
+			t = &offcpuThread{label: label}
+			threads[tk] = t
+		}
+		t.blocked += val.BlockedNs
+		t.runqueue += val.RunqueueNs
+		t.count += val.Count
+
+		if val.BlockedNs+val.RunqueueNs < minNs {
+			continue
+		}
+		frames := resolver.Frames(key.Pid, key.StackId, key.KstackId)
+		if val.BlockedNs > 0 {
+			blocked.Add(key.Pid, key.Tid, label, frames, val.BlockedNs/1000)
+		}
+		if val.RunqueueNs > 0 {
+			runqueue.Add(key.Pid, key.Tid, label, frames, val.RunqueueNs/1000)
+		}
+	}
+	return blocked, runqueue, threads, iter.Err()
+}
+
+// printOffCPUThreads stampa il tempo fuori CPU di ogni thread, dal più fermo
+func printOffCPUThreads(threads map[threadKey]*offcpuThread) {
+	if len(threads) == 0 {
+		return
+	}
+	keys := make([]threadKey, 0, len(threads))
+	for key := range threads {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return threads[keys[i]].blocked+threads[keys[i]].runqueue > threads[keys[j]].blocked+threads[keys[j]].runqueue
+	})
+
+	fmt.Println("\n🧵 Tempo fuori CPU per thread:")
+	for _, key := range keys {
+		t := threads[key]
+		fmt.Printf("   PID %-7d TID %-7d %-26s bloccato %10s | runqueue %10s | %d uscite dalla CPU\n",
+			key.pid, key.tid, "["+t.label+"]", formatDuration(t.blocked), formatDuration(t.runqueue), t.count)
+	}
+}
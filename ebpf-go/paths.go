@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+/*
+Il kernel copia le stringhe dei percorsi così come le ha passate il processo:
+"password.txt" relativo alla cwd, oppure relativo ad una directory aperta (dirfd) per le syscall *at.
+Qui li trasformiamo in percorsi assoluti leggendo /proc/<PID>/cwd e /proc/<PID>/fd/<dirfd>.
+*/
+
+// pathArg indica quale argomento contiene il percorso e da quale dirfd dipende
+type pathArg struct {
+	Index int // Argomento che contiene il puntatore alla stringa
+	Dirfd int // Argomento con la directory di partenza, -1 se relativo alla cwd
+}
+
+// Syscall che ricevono percorsi (al massimo due, es. rename vecchio -> nuovo).
+// Mancano execve (59) ed execveat (322): il kernel legge i percorsi al sys_exit, quando dopo un
+// exec riuscito la vecchia memoria del processo non esiste più. Il binario lo prende exec_event,
+// copiato al sys_enter (vedi ExecAuditor.TakeBinary)
+var pathSyscalls = map[uint32][]pathArg{
+	2: {{0, -1}}, 4: {{0, -1}}, 6: {{0, -1}}, 21: {{0, -1}}, 76: {{0, -1}},
+	80: {{0, -1}}, 82: {{0, -1}, {1, -1}}, 83: {{0, -1}}, 84: {{0, -1}}, 85: {{0, -1}},
+	86: {{0, -1}, {1, -1}}, 87: {{0, -1}}, 88: {{1, -1}}, 89: {{0, -1}}, 90: {{0, -1}},
+	92: {{0, -1}}, 94: {{0, -1}}, 161: {{0, -1}},
+	257: {{1, 0}}, 258: {{1, 0}}, 259: {{1, 0}}, 260: {{1, 0}}, 262: {{1, 0}}, 263: {{1, 0}},
+	264: {{1, 0}, {3, 2}}, 265: {{1, 0}, {3, 2}}, 266: {{2, 1}}, 267: {{1, 0}}, 268: {{1, 0}},
+	269: {{1, 0}}, 280: {{1, 0}}, 316: {{1, 0}, {3, 2}}, 332: {{1, 0}},
+	437: {{1, 0}}, 439: {{1, 0}}, 452: {{1, 0}},
+}
+
+// Valore gemello di struct path_args in trace.c
+type pathArgsValue struct {
+	Idx [2]uint8
+}
+
+// loadPathArgs copia la tabella pathSyscalls nella mappa eBPF, così il kernel sa quali argomenti leggere
+func loadPathArgs(m *ebpf.Map) error {
+	for id, args := range pathSyscalls {
+		val := pathArgsValue{Idx: [2]uint8{0xff, 0xff}}
+		for i, arg := range args {
+			val.Idx[i] = uint8(arg.Index)
+		}
+		key := id
+		if err := m.Put(&key, &val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cString converte un buffer C terminato da '\0' in una stringa Go
+func cString(b []byte) string {
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		return string(b)
+	}
+	// bpf_probe_read_user_str lascia sempre spazio al terminatore: se il buffer
+	// è pieno fino all'ultimo byte, la stringa originale era più lunga ed è stata troncata
+	if i == len(b)-1 {
+		return string(b[:i]) + "…"
+	}
+	return string(b[:i])
+}
+
+// resolvePath rende assoluto un percorso letto dal kernel.
+// La cwd e la tabella dei fd vengono lette adesso, non al momento della syscall:
+// se il processo le ha cambiate nel frattempo il risultato può essere impreciso.
+func resolvePath(pid uint32, path string, dirfd int32) string {
+	if strings.HasPrefix(path, "/") {
+		return filepath.Clean(path)
+	}
+
+	var base string
+	var err error
+	if dirfd == unix.AT_FDCWD {
+		base, err = os.Readlink(fmt.Sprintf("/proc/%d/cwd", pid))
+		if err != nil {
+			base = "<cwd>"
+		}
+	} else {
+		base, err = os.Readlink(fmt.Sprintf("/proc/%d/fd/%d", pid, dirfd))
+		if err != nil {
+			base = fmt.Sprintf("<fd %d>", dirfd)
+		}
+	}
+
+	// Con AT_EMPTY_PATH il percorso è vuoto e la syscall agisce sul dirfd stesso
+	if path == "" {
+		return base
+	}
+	return filepath.Join(base, path)
+}
+
+// formatPaths restituisce i percorsi assoluti della syscall separati da " -> ", oppure "" se non ce ne sono
+func formatPaths(pid uint32, info SyscallInfo) string {
+	args, ok := pathSyscalls[info.SyscallId]
+	if !ok {
+		return ""
+	}
+	var paths []string
+	for i, arg := range args {
+		raw := cString(info.Paths[i][:])
+		// Puntatore NULL (es. utimensat su un fd): non c'è nessun percorso
+		if raw == "" && info.Args[arg.Index] == 0 {
+			continue
+		}
+		dirfd := int32(unix.AT_FDCWD)
+		if arg.Dirfd >= 0 {
+			dirfd = int32(info.Args[arg.Dirfd])
+		}
+		paths = append(paths, resolvePath(pid, raw, dirfd))
+	}
+	return strings.Join(paths, " -> ")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+Albero dei processi monitorati.
+Il kernel aggiunge da solo i figli ai target (trace_sched_fork); qui teniamo traccia
+della discendenza per stamparla e per preparare/liberare lo stato di ogni processo.
+*/
+
+// Sottotipi di EVENT_PROC (enum proc_kind in trace.c)
+const (
+	procFork = 1
+	procExec = 2
+	procExit = 3
+)
+
+// Struttura gemella di struct proc_event in trace.c
+type ProcEvent struct {
+	Type        uint32
+	Kind        uint32
+	Pid         uint32
+	Ppid        uint32
+	TimestampNs uint64
+	ExitCode    int32
+	_           uint32
+	Comm        [16]byte
+	Filename    [256]byte
+}
+
+type procNode struct {
+	pid      uint32
+	ppid     uint32
+	comm     string
+	exe      string
+	children []uint32
+	exited   bool
+	exitCode int32
+}
+
+type ProcessTree struct {
+	nodes map[uint32]*procNode
+	roots []uint32
+}
+
+func NewProcessTree() *ProcessTree {
+	return &ProcessTree{nodes: make(map[uint32]*procNode)}
+}
+
+// readComm legge il nome del processo da /proc, per i processi già vivi all'avvio
+func readComm(pid uint32) string {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return "?"
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// parentPid legge il PID del padre dalla riga "PPid:" di /proc/<PID>/status
+func parentPid(pid uint32) uint32 {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "PPid:"); ok {
+			ppid, _ := strconv.ParseUint(strings.TrimSpace(value), 10, 32)
+			return uint32(ppid)
+		}
+	}
+	return 0
+}
+
+// AddRoot registra un processo scelto dall'utente
+func (pt *ProcessTree) AddRoot(pid uint32) {
+	if _, ok := pt.nodes[pid]; ok {
+		return
+	}
+	pt.nodes[pid] = &procNode{pid: pid, comm: readComm(pid)}
+	pt.roots = append(pt.roots, pid)
+}
+
+// AddChild registra un discendente. Se il padre non è nell'albero il figlio diventa una radice
+// (succede con i cgroup, dove il primo processo visto non ha un padre monitorato)
+func (pt *ProcessTree) AddChild(pid, ppid uint32, comm string) {
+	// Se il PID è stato riutilizzato dopo l'uscita di un vecchio processo, il nuovo nodo lo sostituisce
+	pt.nodes[pid] = &procNode{pid: pid, ppid: ppid, comm: comm}
+	if parent, ok := pt.nodes[ppid]; ok {
+		parent.children = append(parent.children, pid)
+	} else {
+		pt.roots = append(pt.roots, pid)
+	}
+}
+
+// Lineage restituisce la catena degli antenati, es. "1234 (node) → 1300 (node) → 1310 (sh)"
+func (pt *ProcessTree) Lineage(pid uint32) string {
+	var chain []string
+	for seen := 0; seen < 64; seen++ {
+		node, ok := pt.nodes[pid]
+		if !ok {
+			break
+		}
+		chain = append([]string{fmt.Sprintf("%d (%s)", node.pid, node.comm)}, chain...)
+		if node.ppid == 0 {
+			break
+		}
+		pid = node.ppid
+	}
+	return strings.Join(chain, " → ")
+}
+
+// formatExitCode traduce task->exit_code: i 7 bit bassi sono il segnale, i bit 8-15 il codice di uscita
+func formatExitCode(code int32) string {
+	if sig := code & 0x7f; sig != 0 {
+		name := unix.SignalName(syscall.Signal(sig))
+		if name == "" {
+			name = fmt.Sprintf("segnale %d", sig)
+		}
+		return "terminato da " + name
+	}
+	return fmt.Sprintf("codice di uscita %d", (code>>8)&0xff)
+}
+
+// Handle aggiorna l'albero e lo stato dei target in base al tipo di evento
+func (pt *ProcessTree) Handle(ev ProcEvent, eventTime time.Time, targets *TargetSet) {
+	timeStr := eventTime.Format("15:04:05.000000")
+	switch ev.Kind {
+	case procFork:
+		pt.AddChild(ev.Pid, ev.Ppid, cString(ev.Comm[:]))
+		targets.Track(ev.Pid)
+		// Il figlio condivide ancora la memoria (copiata) del padre: il suo Symbolizer
+		// vede gli stessi moduli e, se è un nuovo Node, la sua perf-map
+		targets.Symbolizer(ev.Pid)
+		fmt.Printf("\n🕒 [%s] 🌱 Fork: PID %d ← %d | %s\n", timeStr, ev.Pid, ev.Ppid, pt.Lineage(ev.Pid))
+
+	case procExec:
+		exe := cString(ev.Filename[:])
+		if node, ok := pt.nodes[ev.Pid]; ok {
+			node.exe = exe
+			node.comm = cString(ev.Comm[:])
+		}
+		// Nuovo programma, nuove mappe di memoria: il vecchio Symbolizer non serve più
+		targets.ResetSymbolizer(ev.Pid)
+		fmt.Printf("\n🕒 [%s] 🔁 Exec: PID %d → %s | %s\n", timeStr, ev.Pid, exe, pt.Lineage(ev.Pid))
+
+	case procExit:
+		if node, ok := pt.nodes[ev.Pid]; ok {
+			node.exited = true
+			node.exitCode = ev.ExitCode
+		}
+		targets.Forget(ev.Pid)
+		fmt.Printf("\n🕒 [%s] 💀 Uscita: PID %d (%s), %s\n", timeStr, ev.Pid, cString(ev.Comm[:]), formatExitCode(ev.ExitCode))
+	}
+}
+
+// Print stampa l'albero completo dei processi visti
+func (pt *ProcessTree) Print() {
+	if len(pt.nodes) <= len(pt.roots) && len(pt.roots) <= 1 {
+		return // Nessun figlio: l'albero non aggiunge informazioni
+	}
+	fmt.Println("\n🌳 Albero dei processi monitorati:")
+	roots := append([]uint32(nil), pt.roots...)
+	sort.Slice(roots, func(i, j int) bool { return roots[i] < roots[j] })
+	for _, pid := range roots {
+		pt.printNode(pid, "   ")
+	}
+}
+
+func (pt *ProcessTree) printNode(pid uint32, indent string) {
+	node, ok := pt.nodes[pid]
+	if !ok {
+		return
+	}
+	line := fmt.Sprintf("%s%d %s", indent, node.pid, node.comm)
+	if node.exe != "" {
+		line += " [" + node.exe + "]"
+	}
+	if node.exited {
+		line += " — " + formatExitCode(node.exitCode)
+	}
+	fmt.Println(line)
+	for _, child := range node.children {
+		pt.printNode(child, indent+"   ")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+/*
+Modalità profile: profiler CPU a campionamento.
+Le syscall non dicono nulla del codice che macina CPU senza chiamare il kernel
+(es. calculateFibonacci in un ciclo). Qui apriamo su ogni CPU un evento perf software
+(CPU clock) alla frequenza scelta e ci agganciamo profile_cpu: il kernel conta gli stack
+dei thread monitorati che stanno girando, e alla fine li risolviamo con lo stesso
+Symbolizer (JIT + ELF) del monitor delle syscall.
+*/
+
+// Struttura gemella di struct profile_key in trace.c
+type profileKey struct {
+	Pid      uint32
+	Tid      uint32
+	StackId  int32
+	KstackId int32
+}
+
+func runProfile(args []string) {
+	fs := flag.NewFlagSet("profile", flag.ExitOnError)
+	var opts modeOptions
+	opts.register(fs)
+	freq := fs.Uint64("freq", 99, "campioni al secondo per CPU (99 evita di andare a tempo con i timer a 100Hz)")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Uso corretto: sudo ./monitor profile [--freq 99] [--duration 30s] [--top 20] [--folded out.folded] [--cgroup <percorso>]... [<PID_NODEJS>...]\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 && len(opts.cgroups) == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	pids := parsePIDs(fs.Args())
+
+	objs, targets := loadModeObjects(pids, opts.cgroups)
+	defer objs.Close()
+	// Come nel monitor delle syscall prepariamo subito i Symbolizer dei PID noti: un processo
+	// (o un worker di cluster) che termina durante la raccolta non ha più /proc/<PID>/maps
+	targets.PrepareSymbolizers()
+
+	cpus, err := onlineCPUs()
+	if err != nil {
+		log.Fatalf("Impossibile leggere le CPU attive: %v", err)
+	}
+	var fds []int
+	for _, cpu := range cpus {
+		fd, err := openCPUClock(cpu, *freq, objs.ProfileCpu)
+		if err != nil {
+			log.Fatalf("Errore apertura evento perf sulla CPU %d: %v", cpu, err)
+		}
+		fds = append(fds, fd)
+	}
+
+	fmt.Printf("🔥 Profiling CPU di %s a %d Hz su %d CPU avviato. Ctrl+C per il report.\n", targets, *freq, len(cpus))
+	start := time.Now()
+	waitForStopTicking(opts.duration, time.Second, func() { trackProfileTargets(objs.ProfileCounts, targets) })
+	elapsed := time.Since(start)
+
+	// Fermiamo il campionamento prima di leggere i contatori
+	for _, fd := range fds {
+		unix.Close(fd)
+	}
+
+	ksyms, err := LoadKernelSymbols()
+	if err != nil {
+		log.Printf("⚠️  Simboli del kernel non disponibili: %v", err)
+	}
+	// Ultimo aggiornamento prima di risolvere: le funzioni compilate negli ultimi istanti.
+	// I processi già terminati tengono le mappe lette all'ultimo giro
+	targets.ReloadProcMaps()
+	targets.UpdatePerfMaps()
+	report, err := collectProfile(objs.ProfileCounts, objs.ProfileStacks, targets, ksyms)
+	if err != nil {
+		log.Fatalf("Errore lettura campioni: %v", err)
+	}
+
+	fmt.Printf("\n🔥 Profilo CPU: %d campioni in %s (%d Hz su %d CPU)\n",
+		report.Total(), elapsed.Round(time.Millisecond), *freq, len(cpus))
+	report.PrintTopFunctions("Funzioni con più campioni (self)", opts.top)
+	report.PrintTop("Stack più frequenti", opts.top)
+	if opts.folded != "" {
+		if err := report.WriteFolded(opts.folded); err != nil {
+			log.Fatalf("Errore scrittura %s: %v", opts.folded, err)
+		}
+		fmt.Printf("\n📝 Stack in formato folded scritti in %s (flamegraph.pl %s > cpu.svg)\n", opts.folded, opts.folded)
+	}
+}
+
+// openCPUClock apre l'evento perf CPU clock su una CPU, per tutti i processi (il filtro sui
+// target lo fa profile_cpu), e ci aggancia il programma eBPF
+func openCPUClock(cpu int, freq uint64, prog *ebpf.Program) (int, error) {
+	attr := unix.PerfEventAttr{
+		Type:   unix.PERF_TYPE_SOFTWARE,
+		Config: unix.PERF_COUNT_SW_CPU_CLOCK,
+		Sample: freq,
+		Bits:   unix.PerfBitFreq, // Sample è una frequenza in Hz, non un periodo
+	}
+	attr.Size = uint32(unsafe.Sizeof(attr))
+
+	fd, err := unix.PerfEventOpen(&attr, -1, cpu, -1, unix.PERF_FLAG_FD_CLOEXEC)
+	if err != nil {
+		return -1, err
+	}
+	if err := unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_SET_BPF, prog.FD()); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("aggancio programma: %w", err)
+	}
+	if err := unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_ENABLE, 0); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("attivazione evento: %w", err)
+	}
+	return fd, nil
+}
+
+// onlineCPUs legge le CPU attive da /sys/devices/system/cpu/online (es. "0-3,6")
+func onlineCPUs() ([]int, error) {
+	data, err := os.ReadFile("/sys/devices/system/cpu/online")
+	if err != nil {
+		return nil, err
+	}
+	var cpus []int
+	for _, part := range strings.Split(strings.TrimSpace(string(data)), ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		lo, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("formato non valido: %q", part)
+		}
+		hi := lo
+		if isRange {
+			if hi, err = strconv.Atoi(last); err != nil {
+				return nil, fmt.Errorf("formato non valido: %q", part)
+			}
+		}
+		for cpu := lo; cpu <= hi; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
+}
+
+// trackProfileTargets crea il Symbolizer dei processi comparsi nei campioni (figli, processi
+// dei cgroup) e rilegge le mappe di tutti, finché i processi sono vivi
+func trackProfileTargets(counts *ebpf.Map, targets *TargetSet) {
+	var (
+		key   profileKey
+		count uint64
+	)
+	iter := counts.Iterate()
+	for iter.Next(&key, &count) {
+		targets.Symbolizer(key.Pid)
+	}
+	targets.ReloadProcMaps()
+	targets.UpdatePerfMaps()
+}
+
+// collectProfile legge i contatori dei campioni e risolve ogni stack una sola volta:
+// prima i frame del kernel (se il campione è caduto dentro una syscall o un interrupt),
+// poi quelli utente
+func collectProfile(counts, stackMap *ebpf.Map, targets *TargetSet, ksyms *KernelSymbols) (*StackReport, error) {
+	report := NewStackReport(func(v uint64) string { return fmt.Sprintf("%d campioni", v) })
+	resolver := newStackResolver(stackMap, targets, ksyms)
+	labels := NewThreadLabeler()
+
+	var (
+		key   profileKey
+		count uint64
+	)
+	iter := counts.Iterate()
+	for iter.Next(&key, &count) {
+		frames := resolver.Frames(key.Pid, key.StackId, key.KstackId)
+		report.Add(key.Pid, key.Tid, labels.Label(key.Pid, key.Tid, "?"), frames, count)
+	}
+	return report, iter.Err()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+/*
+Segnali e autopsia dei processi terminati.
+Quando un processo Node muore (SIGSEGV in un addon nativo, SIGABRT da un errore fatale
+di V8, SIGKILL dell'OOM killer) vogliamo sapere chi ha inviato il segnale, dove si trovava
+il thread che lo ha ricevuto e con quale codice è uscito il processo. Il kernel manda
+la generazione del segnale (con il mittente) e la consegna (con lo stack utente del thread);
+all'uscita del processo stampiamo il riepilogo.
+*/
+
+// Sottotipi di EVENT_SIGNAL (enum signal_kind in trace.c)
+const (
+	signalGenerate = 1
+	signalDeliver  = 2
+)
+
+// Valori speciali di sa_handler
+const (
+	sigDfl = 0
+	sigIgn = 1
+)
+
+// Struttura gemella di struct signal_event in trace.c
+type SignalEvent struct {
+	Type        uint32
+	Kind        uint32
+	Pid         uint32
+	Tid         uint32
+	SenderPid   uint32
+	SenderTid   uint32
+	Sig         int32
+	Code        int32
+	TimestampNs uint64
+	Handler     uint64
+	Comm        [16]byte
+	NrFrames    uint32
+	_           uint32
+	Frames      [127]uint64
+}
+
+// signalRecord è l'ultima consegna di un segnale a un processo, con lo stack già risolto:
+// all'uscita le mappe di memoria del processo non esistono più
+type signalRecord struct {
+	sig    int32
+	tid    uint32
+	label  string
+	sender string
+	frames []string
+}
+
+type SignalTracker struct {
+	senders   map[[2]uint32]string // (pid, segnale) -> mittente dell'ultima generazione
+	delivered map[uint32]*signalRecord
+}
+
+func NewSignalTracker() *SignalTracker {
+	return &SignalTracker{
+		senders:   make(map[[2]uint32]string),
+		delivered: make(map[uint32]*signalRecord),
+	}
+}
+
+// signalName restituisce il nome del segnale, es. "SIGSEGV"
+func signalName(sig int32) string {
+	if name := unix.SignalName(syscall.Signal(sig)); name != "" {
+		return name
+	}
+	return fmt.Sprintf("segnale %d", sig)
+}
+
+// describeSender descrive chi ha generato il segnale: con si_code > 0 è il kernel
+// (fault, OOM killer...), altrimenti un processo con kill/tgkill
+func describeSender(ev SignalEvent) string {
+	switch {
+	case ev.Code > 0 && ev.SenderPid == ev.Pid:
+		return fmt.Sprintf("kernel, nel thread %d stesso (si_code %d)", ev.SenderTid, ev.Code)
+	case ev.Code > 0:
+		return fmt.Sprintf("kernel, durante PID %d (%s) (si_code %d)", ev.SenderPid, cString(ev.Comm[:]), ev.Code)
+	case ev.SenderPid == ev.Pid:
+		return fmt.Sprintf("il processo stesso, TID %d (es. abort())", ev.SenderTid)
+	default:
+		return fmt.Sprintf("PID %d TID %d (%s)", ev.SenderPid, ev.SenderTid, cString(ev.Comm[:]))
+	}
+}
+
+// Handle registra il mittente alla generazione e, alla consegna, stampa il segnale con
+// lo stack del thread se il processo non ha un handler (quindi sta per morire)
+func (t *SignalTracker) Handle(ev SignalEvent, eventTime time.Time, symb *Symbolizer, labels *ThreadLabeler) {
+	key := [2]uint32{ev.Pid, uint32(ev.Sig)}
+	if ev.Kind == signalGenerate {
+		t.senders[key] = describeSender(ev)
+		return
+	}
+
+	sender, ok := t.senders[key]
+	if !ok {
+		sender = "sconosciuto (generato prima dell'avvio o evento perso)"
+	}
+	delete(t.senders, key)
+
+	n := min(int(ev.NrFrames), len(ev.Frames))
+	rec := &signalRecord{
+		sig:    ev.Sig,
+		tid:    ev.Tid,
+		label:  labels.Label(ev.Pid, ev.Tid, cString(ev.Comm[:])),
+		sender: sender,
+		frames: resolveFrames(symb, ev.Frames[:n]),
+	}
+	t.delivered[ev.Pid] = rec
+
+	handler := "azione predefinita"
+	switch ev.Handler {
+	case sigDfl:
+	case sigIgn:
+		handler = "ignorato"
+	default:
+		handler = fmt.Sprintf("handler 0x%x", ev.Handler)
+	}
+	fmt.Printf("\n🕒 [%s] ⚡ Segnale %s a PID %d | TID %d [%s] | inviato da: %s | %s\n",
+		eventTime.Format("15:04:05.000000"), signalName(ev.Sig), ev.Pid, ev.Tid, rec.label, sender, handler)
+	if ev.Handler == sigDfl {
+		if len(rec.frames) == 0 {
+			fmt.Println("      ⚠️  Stack non disponibile")
+		}
+		printStack(rec.frames)
+	}
+}
+
+// HandleExit stampa l'autopsia di un processo terminato da un segnale: il segnale, chi l'ha
+// inviato e lo stack finale del thread che l'ha ricevuto
+func (t *SignalTracker) HandleExit(ev ProcEvent) {
+	rec := t.delivered[ev.Pid]
+	delete(t.delivered, ev.Pid)
+	for key := range t.senders {
+		if key[0] == ev.Pid {
+			delete(t.senders, key)
+		}
+	}
+
+	sig := ev.ExitCode & 0x7f
+	if sig == 0 {
+		return // Uscita normale: il codice lo ha già stampato l'albero dei processi
+	}
+	fmt.Printf("\n🧾 Autopsia PID %d (%s): %s", ev.Pid, cString(ev.Comm[:]), formatExitCode(ev.ExitCode))
+	if ev.ExitCode&0x80 != 0 {
+		fmt.Print(" (core dump)")
+	}
+	fmt.Println()
+	if rec == nil || rec.sig != sig {
+		fmt.Println("      Consegna del segnale non osservata (es. SIGKILL a un processo già in uscita)")
+		return
+	}
+	fmt.Printf("      📨 Inviato da: %s\n", rec.sender)
+	fmt.Printf("      🧵 Thread: TID %d [%s], stack finale:\n", rec.tid, rec.label)
+	printStack(rec.frames)
+}
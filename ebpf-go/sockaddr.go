@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+/*
+Il kernel copia la struct sockaddr così com'è in memoria; qui la trasformiamo in un indirizzo leggibile:
+	AF_INET  -> 104.21.3.4:443
+	AF_INET6 -> [2606:4700::1]:443
+	AF_UNIX  -> /run/app.sock (oppure @nome per i socket astratti)
+*/
+
+// sockaddrArg indica dove si trovano la sockaddr e la sua lunghezza tra gli argomenti
+type sockaddrArg struct {
+	AddrIndex int
+	LenIndex  int
+	LenIsPtr  bool // accept/accept4: la lunghezza è un socklen_t * scritto dal kernel
+}
+
+// Per accept e accept4 senza buffer (libuv passa NULL, NULL) il kernel legge il peer dal
+// socket restituito: arrivano comunque i byte di una sockaddr_in o sockaddr_in6
+
+// Syscall di rete con un indirizzo
+var sockaddrSyscalls = map[uint32]sockaddrArg{
+	42:  {1, 2, false}, // connect(fd, uservaddr, addrlen)
+	43:  {1, 2, true},  // accept(fd, upeer_sockaddr, upeer_addrlen)
+	44:  {4, 5, false}, // sendto(fd, buff, len, flags, addr, addr_len)
+	49:  {1, 2, false}, // bind(fd, umyaddr, addrlen)
+	288: {1, 2, true},  // accept4(fd, upeer_sockaddr, upeer_addrlen, flags)
+}
+
+// Valore gemello di struct sockaddr_args in trace.c
+type sockaddrArgsValue struct {
+	AddrIdx  uint8
+	LenIdx   uint8
+	LenIsPtr uint8
+}
+
+// loadSockaddrArgs copia la tabella sockaddrSyscalls nella mappa eBPF
+func loadSockaddrArgs(m *ebpf.Map) error {
+	for id, arg := range sockaddrSyscalls {
+		val := sockaddrArgsValue{AddrIdx: uint8(arg.AddrIndex), LenIdx: uint8(arg.LenIndex)}
+		if arg.LenIsPtr {
+			val.LenIsPtr = 1
+		}
+		key := id
+		if err := m.Put(&key, &val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decodeSockaddr traduce i byte grezzi della sockaddr in una stringa.
+// La famiglia è in host order (little endian), porta e indirizzo in network order (big endian).
+func decodeSockaddr(raw []byte) string {
+	if len(raw) < 2 {
+		return ""
+	}
+	family := binary.LittleEndian.Uint16(raw[0:2])
+	switch family {
+	case unix.AF_INET:
+		if len(raw) < 8 {
+			break
+		}
+		port := binary.BigEndian.Uint16(raw[2:4])
+		addr := netip.AddrFrom4([4]byte(raw[4:8]))
+		return netip.AddrPortFrom(addr, port).String()
+	case unix.AF_INET6:
+		if len(raw) < 24 {
+			break
+		}
+		port := binary.BigEndian.Uint16(raw[2:4])
+		addr := netip.AddrFrom16([16]byte(raw[8:24]))
+		// Gli indirizzi IPv4 mappati (::ffff:1.2.3.4) sono più leggibili nella forma IPv4
+		if addr.Is4In6() {
+			addr = addr.Unmap()
+		}
+		return netip.AddrPortFrom(addr, port).String()
+	case unix.AF_UNIX:
+		path := raw[2:]
+		// Socket astratto: il nome inizia con '\0' e non è un file sul disco
+		if len(path) > 0 && path[0] == 0 {
+			return "@" + string(bytes.TrimRight(path[1:], "\x00"))
+		}
+		if i := bytes.IndexByte(path, 0); i >= 0 {
+			path = path[:i]
+		}
+		return string(path)
+	}
+	return fmt.Sprintf("famiglia %d", family)
+}
+
+// formatSockaddr restituisce "connect 104.21.3.4:443", oppure "" se l'evento non ha un indirizzo
+func formatSockaddr(info SyscallInfo) string {
+	if info.AddrLen == 0 || info.AddrLen > uint32(len(info.Addr)) {
+		return ""
+	}
+	addr := decodeSockaddr(info.Addr[:info.AddrLen])
+	if addr == "" {
+		return ""
+	}
+	if sockaddrSyscalls[info.SyscallId].LenIsPtr {
+		return fmt.Sprintf("%s dal peer %s", getSyscallName(info.SyscallId), addr)
+	}
+	return fmt.Sprintf("%s %s", getSyscallName(info.SyscallId), addr)
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+/*
+Report degli stack delle modalità di profiling.
+Ogni voce è uno stack (già risolto, dal frame più interno al più esterno) con un valore:
+numero di campioni per il profiler CPU, nanosecondi per le attese. Da qui si stampano
+gli stack più pesanti, le funzioni con più valore "self" (frame più interno) e il
+formato folded per i flame graph.
+*/
+
+type stackEntry struct {
+	pid    uint32
+	tid    uint32
+	label  string   // Etichetta del thread (main, libuv-worker-1...)
+	frames []string // frames[0] è il frame più interno
+	value  uint64
+}
+
+type StackReport struct {
+	entries []*stackEntry
+	total   uint64
+	format  func(value uint64) string // es. "123 campioni" oppure "12ms"
+
+	// Se true, la funzione "self" è il primo frame utente e non il primo del kernel:
+	// negli stack di attesa il frame più interno è sempre lo scheduler
+	userSelf bool
+}
+
+func NewStackReport(format func(value uint64) string) *StackReport {
+	return &StackReport{format: format}
+}
+
+// formatMicros formatta i valori dei report di attesa, che sono in µs:
+// in ns i numeri del formato folded sarebbero enormi
+func formatMicros(us uint64) string {
+	return formatDuration(us * 1000)
+}
+
+// Add aggiunge uno stack al report
+func (r *StackReport) Add(pid, tid uint32, label string, frames []string, value uint64) {
+	r.entries = append(r.entries, &stackEntry{pid: pid, tid: tid, label: label, frames: frames, value: value})
+	r.total += value
+}
+
+// Total restituisce la somma dei valori di tutti gli stack
+func (r *StackReport) Total() uint64 {
+	return r.total
+}
+
+// percent restituisce la quota di value sul totale
+func (r *StackReport) percent(value uint64) float64 {
+	if r.total == 0 {
+		return 0
+	}
+	return float64(value) / float64(r.total) * 100
+}
+
+// PrintTop stampa gli n stack con il valore più alto
+func (r *StackReport) PrintTop(title string, n int) {
+	if len(r.entries) == 0 {
+		return
+	}
+	entries := append([]*stackEntry(nil), r.entries...)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].value > entries[j].value })
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+
+	fmt.Printf("\n📚 %s:\n", title)
+	for i, e := range entries {
+		fmt.Printf("   #%-3d %5.1f%% %s | PID %d | TID %d [%s]\n",
+			i+1, r.percent(e.value), r.format(e.value), e.pid, e.tid, e.label)
+		for j, name := range e.frames {
+			fmt.Printf("      [%2d] %s\n", j, name)
+		}
+	}
+}
+
+// PrintTopFunctions stampa le n funzioni con più valore "self", cioè in cima allo stack
+func (r *StackReport) PrintTopFunctions(title string, n int) {
+	if len(r.entries) == 0 {
+		return
+	}
+	self := make(map[string]uint64)
+	for _, e := range r.entries {
+		self[r.selfFrame(e.frames)] += e.value
+	}
+	names := make([]string, 0, len(self))
+	for name := range self {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if self[names[i]] != self[names[j]] {
+			return self[names[i]] > self[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > n {
+		names = names[:n]
+	}
+
+	fmt.Printf("\n📊 %s:\n", title)
+	for _, name := range names {
+		fmt.Printf("   %5.1f%% %16s  %s\n", r.percent(self[name]), r.format(self[name]), name)
+	}
+}
+
+// selfFrame sceglie il frame a cui attribuire il valore "self" di uno stack
+func (r *StackReport) selfFrame(frames []string) string {
+	for _, name := range frames {
+		if !r.userSelf || !strings.HasPrefix(name, "[K] ") {
+			return name
+		}
+	}
+	return "(stack vuoto)"
+}
+
+// WriteFolded scrive gli stack nel formato di flamegraph.pl: una riga per stack,
+// i frame dal più esterno al più interno separati da ';' e infine il valore.
+// Il primo frame è il processo (es. "node-1234"), così un flame graph può contenerne più di uno
+func (r *StackReport) WriteFolded(path string) error {
+	folded := make(map[string]uint64)
+	for _, e := range r.entries {
+		parts := make([]string, 0, len(e.frames)+1)
+		parts = append(parts, fmt.Sprintf("%s-%d", readComm(e.pid), e.pid))
+		for i := len(e.frames) - 1; i >= 0; i-- {
+			parts = append(parts, strings.ReplaceAll(e.frames[i], ";", ":"))
+		}
+		folded[strings.Join(parts, ";")] += e.value
+	}
+	lines := make([]string, 0, len(folded))
+	for stack := range folded {
+		lines = append(lines, stack)
+	}
+	sort.Strings(lines)
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, stack := range lines {
+		fmt.Fprintf(w, "%s %d\n", stack, folded[stack])
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// stackResolver traduce le coppie (stack utente, stack del kernel) delle modalità di
+// profiling, risolvendo ogni stack una sola volta. Il risultato ha prima i frame del
+// kernel e poi quelli utente, come uno stack unico
+type stackResolver struct {
+	stackMap *ebpf.Map
+	targets  *TargetSet
+	ksyms    *KernelSymbols
+	user     map[[2]uint32][]string // (pid, stack_id) -> nomi
+	kernel   map[int32][]string
+}
+
+func newStackResolver(stackMap *ebpf.Map, targets *TargetSet, ksyms *KernelSymbols) *stackResolver {
+	return &stackResolver{
+		stackMap: stackMap,
+		targets:  targets,
+		ksyms:    ksyms,
+		user:     make(map[[2]uint32][]string),
+		kernel:   make(map[int32][]string),
+	}
+}
+
+// ForgetPid scarta gli stack utente risolti di un processo (dopo un exec gli stessi
+// indirizzi appartengono a un altro programma)
+func (r *stackResolver) ForgetPid(pid uint32) {
+	for key := range r.user {
+		if key[0] == pid {
+			delete(r.user, key)
+		}
+	}
+}
+
+// Frames restituisce lo stack risolto; un kstackId negativo significa "nessun frame del kernel"
+func (r *stackResolver) Frames(pid uint32, stackId, kstackId int32) []string {
+	var frames []string
+	if kstackId >= 0 {
+		names, ok := r.kernel[kstackId]
+		if !ok {
+			ips, _ := lookupStack(r.stackMap, kstackId)
+			for _, ip := range ips {
+				names = append(names, r.ksyms.Function(ip))
+			}
+			r.kernel[kstackId] = names
+		}
+		frames = append(frames, names...)
+	}
+
+	key := [2]uint32{pid, uint32(stackId)}
+	names, ok := r.user[key]
+	if !ok {
+		ips, err := lookupStack(r.stackMap, stackId)
+		if err != nil {
+			names = []string{"(stack utente non disponibile)"}
+		} else {
+			names = resolveFrames(r.targets.Symbolizer(pid), ips)
+		}
+		r.user[key] = names
+	}
+	return append(frames, names...)
+}
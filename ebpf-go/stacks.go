@@ -0,0 +1,164 @@
+package main
+
+import (
+	"github.com/cilium/ebpf"
+)
+
+/*
+Gestione della StackMap.
+stack_map ha 1024 posti e il kernel non li libera mai: su un server occupato, dopo qualche
+minuto ogni stack nuovo fa fallire bpf_get_stackid. Il kernel conta in stack_refs quanti
+eventi fanno riferimento a ogni stack_id, noi contiamo quanti ne abbiamo consumati: quando i
+due numeri coincidono nessun evento in volo lo usa più, e possiamo cancellarlo.
+
+Dopo la cancellazione lo stesso id può essere riassegnato a uno stack diverso: ogni id ha
+quindi una generazione, incrementata a ogni cancellazione, che fa parte della chiave
+della cache degli stack già risolti. Un id riusato non mostra mai lo stack vecchio.
+
+In modalità aggregata gli eventi non passano dal ring buffer: ogni syscall contata in
+agg_map è un riferimento, e Go li consuma tutti insieme quando svuota la mappa.
+*/
+
+// stackCacheKey identifica uno stack risolto: lo stesso id in due processi (o dopo
+// un riuso dell'id) va risolto di nuovo
+type stackCacheKey struct {
+	pid uint32
+	id  int32
+	gen uint32
+}
+
+// resolvedStack è uno stack risolto con la generazione del Symbolizer che l'ha risolto
+type resolvedStack struct {
+	names   []string
+	symbGen uint64
+}
+
+type StackTable struct {
+	stackMap *ebpf.Map
+	refsMap  *ebpf.Map
+
+	consumed  map[int32]uint64 // id -> riferimenti consumati dall'avvio
+	candidate map[int32]uint64 // id -> riferimenti prodotti, se allo sweep precedente erano tutti consumati
+	freedAt   map[int32]uint64 // id -> riferimenti prodotti al momento della cancellazione
+	gen       map[int32]uint32 // id -> generazione
+	cache     map[stackCacheKey]resolvedStack
+	reclaimed uint64
+}
+
+func NewStackTable(stackMap, refsMap *ebpf.Map) *StackTable {
+	return &StackTable{
+		stackMap:  stackMap,
+		refsMap:   refsMap,
+		consumed:  make(map[int32]uint64),
+		candidate: make(map[int32]uint64),
+		freedAt:   make(map[int32]uint64),
+		gen:       make(map[int32]uint32),
+		cache:     make(map[stackCacheKey]resolvedStack),
+	}
+}
+
+// Frames restituisce gli indirizzi di uno stack
+func (t *StackTable) Frames(id int32) ([]uint64, error) {
+	return lookupStack(t.stackMap, id)
+}
+
+// Resolve restituisce i nomi dei frame di uno stack, risolvendoli una sola volta
+// per ogni (processo, id, generazione), finché il Symbolizer non cambia le sue risposte
+// (es. nuove librerie al posto di altre)
+func (t *StackTable) Resolve(pid uint32, id int32, symb *Symbolizer) ([]string, error) {
+	key := stackCacheKey{pid: pid, id: id, gen: t.gen[id]}
+	if cached, ok := t.cache[key]; ok && cached.symbGen == symb.Generation() {
+		return cached.names, nil
+	}
+	frames, err := t.Frames(id)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(frames))
+	complete := true
+	for i, ip := range frames {
+		names[i] = symb.Resolve(ip)
+		complete = complete && !IsUnresolved(names[i])
+	}
+	// Uno stack con frame sconosciuti non va in cache: la perf-map o le mappe del
+	// processo potrebbero completarlo al prossimo evento
+	if complete {
+		t.cache[key] = resolvedStack{names: names, symbGen: symb.Generation()}
+	}
+	return names, nil
+}
+
+// Release segna come consumato un riferimento allo stack: va chiamato per ogni
+// stack_id di ogni evento letto dal ring buffer, dopo averlo stampato
+func (t *StackTable) Release(id int32) {
+	t.ReleaseN(id, 1)
+}
+
+// ReleaseN segna come consumati n riferimenti allo stack: in modalità aggregata
+// una chiave di agg_map porta tanti riferimenti quante syscall ha contato
+func (t *StackTable) ReleaseN(id int32, n uint64) {
+	if id >= 0 {
+		t.consumed[id] += n
+	}
+}
+
+// Generation restituisce la generazione corrente dell'id: cambia ogni volta che
+// l'id viene liberato, e quindi può indicare uno stack diverso
+func (t *StackTable) Generation(id int32) uint32 {
+	return t.gen[id]
+}
+
+// ForgetPid scarta gli stack risolti di un processo: dopo un exec gli stessi
+// indirizzi appartengono a un altro programma
+func (t *StackTable) ForgetPid(pid uint32) {
+	for key := range t.cache {
+		if key.pid == pid {
+			delete(t.cache, key)
+		}
+	}
+}
+
+// Sweep cancella da stack_map gli stack che nessun evento usa più.
+// Un id viene cancellato solo se prodotti e consumati coincidono per due sweep di fila:
+// tra bpf_get_stackid e l'incremento di stack_refs passa un istante in cui il kernel
+// usa già l'id senza averlo ancora contato
+func (t *StackTable) Sweep() error {
+	for id, consumed := range t.consumed {
+		key := uint32(id)
+		var produced uint64
+		if err := t.refsMap.Lookup(&key, &produced); err != nil {
+			return err
+		}
+		if freed, ok := t.freedAt[id]; ok && freed == produced {
+			continue // Già cancellato e non più usato dal kernel
+		}
+		if produced != consumed {
+			delete(t.candidate, id) // Ci sono ancora eventi in volo
+			continue
+		}
+		if prev, ok := t.candidate[id]; !ok || prev != produced {
+			t.candidate[id] = produced
+			continue
+		}
+
+		delete(t.candidate, id)
+		// I contatori non si azzerano: al prossimo uso dell'id ripartiamo da qui
+		t.freedAt[id] = produced
+		if err := t.stackMap.Delete(&id); err != nil {
+			continue // Già sparito: nulla da liberare
+		}
+		t.gen[id]++
+		t.reclaimed++
+		for key := range t.cache {
+			if key.id == id {
+				delete(t.cache, key)
+			}
+		}
+	}
+	return nil
+}
+
+// Reclaimed restituisce quanti stack sono stati liberati dall'avvio
+func (t *StackTable) Reclaimed() uint64 {
+	return t.reclaimed
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cilium/ebpf"
+)
+
+/*
+Contabilità delle perdite.
+Il ring buffer non sovrascrive gli eventi, ma può riempirsi; la stack_map ha 1024 entry e
+può andare in collisione; in user space un record può non decodificarsi o uno stack sparire
+dalla mappa. Ogni caso viene contato (nel kernel in stats_map, per CPU, e qui in Go)
+e riportato periodicamente e all'uscita: se nulla è andato perso, lo possiamo dimostrare.
+*/
+
+// Indici di stats_map (enum stat_counter in trace.c)
+const (
+	statSyscalls = iota
+	statStackUser
+	statStackKernel
+	statRingbufFull
+	statRingbufOther
+	statEnterFull
+	statAggFull
+	statThreadFull
+	statUprobeLost
+	nrStats
+)
+
+// Descrizione di ogni contatore del kernel (STAT_SYSCALLS è il denominatore, non una perdita)
+var statNames = [nrStats]string{
+	statStackUser:    "stack utente non catturati (stack_map piena o collisione)",
+	statStackKernel:  "stack del kernel non catturati",
+	statRingbufFull:  "eventi syscall persi (ring buffer pieno)",
+	statRingbufOther: "eventi exec, processo, segnali o mappe persi (ring buffer pieno)",
+	statEnterFull:    "ingressi non salvati in enter_map",
+	statAggFull:      "syscall, campioni o page fault non contati (mappa dei contatori piena)",
+	statThreadFull:   "stati per thread non salvati (offcpu_start_map)",
+	statUprobeLost:   "eventi di uretprobe persi (ingresso ricorsivo sovrascritto o ritorno senza ingresso)",
+}
+
+// Contatori di user space
+const (
+	userDecodeErrors = iota
+	userStackLookups
+	userShortRecords
+	userUnknownRecords
+	nrUserStats
+)
+
+var userStatNames = [nrUserStats]string{
+	userDecodeErrors:   "record non decodificabili",
+	userStackLookups:   "stack non trovati nella StackMap",
+	userShortRecords:   "record troppo corti",
+	userUnknownRecords: "record di tipo sconosciuto",
+}
+
+type LossStats struct {
+	statsMap  *ebpf.Map
+	threshold float64 // Percentuale di perdita oltre la quale avvisare
+
+	kernel     [nrStats]uint64
+	user       [nrUserStats]uint64
+	lastKernel [nrStats]uint64 // Valori all'ultimo report, per calcolare l'intervallo
+	lastUser   [nrUserStats]uint64
+}
+
+func NewLossStats(statsMap *ebpf.Map, threshold float64) *LossStats {
+	return &LossStats{statsMap: statsMap, threshold: threshold}
+}
+
+// Count registra una perdita avvenuta in user space
+func (s *LossStats) Count(idx int) {
+	s.user[idx]++
+}
+
+// read somma le copie per CPU dei contatori del kernel
+func (s *LossStats) read() error {
+	for i := uint32(0); i < nrStats; i++ {
+		var perCPU []uint64
+		if err := s.statsMap.Lookup(&i, &perCPU); err != nil {
+			return err
+		}
+		var total uint64
+		for _, v := range perCPU {
+			total += v
+		}
+		s.kernel[i] = total
+	}
+	return nil
+}
+
+// lossLines restituisce le righe dei contatori non nulli, con il delta rispetto a base
+func (s *LossStats) lossLines(baseKernel [nrStats]uint64, baseUser [nrUserStats]uint64) (lines []string, lostEvents uint64) {
+	for i := statStackUser; i < nrStats; i++ {
+		if n := s.kernel[i] - baseKernel[i]; n > 0 {
+			lines = append(lines, fmt.Sprintf("%d %s", n, statNames[i]))
+			if i == statRingbufFull || i == statEnterFull || i == statAggFull {
+				lostEvents += n
+			}
+		}
+	}
+	for i := 0; i < nrUserStats; i++ {
+		if n := s.user[i] - baseUser[i]; n > 0 {
+			lines = append(lines, fmt.Sprintf("%d %s", n, userStatNames[i]))
+			if i == userDecodeErrors || i == userShortRecords {
+				lostEvents += n
+			}
+		}
+	}
+	return lines, lostEvents
+}
+
+// Report stampa le perdite dell'ultimo intervallo (solo se ce ne sono) e avvisa se
+// la percentuale di syscall perse supera la soglia
+func (s *LossStats) Report() error {
+	if err := s.read(); err != nil {
+		return err
+	}
+	lines, lost := s.lossLines(s.lastKernel, s.lastUser)
+	seen := s.kernel[statSyscalls] - s.lastKernel[statSyscalls]
+	s.lastKernel, s.lastUser = s.kernel, s.user
+
+	if len(lines) == 0 {
+		return nil
+	}
+	fmt.Printf("\n📉 Perdite nell'ultimo intervallo (%d syscall viste): %s\n", seen, strings.Join(lines, ", "))
+	s.warn(lost, seen)
+	return nil
+}
+
+// PrintSummary stampa il bilancio completo dall'avvio, anche quando non si è perso nulla
+func (s *LossStats) PrintSummary() error {
+	if err := s.read(); err != nil {
+		return err
+	}
+	lines, lost := s.lossLines([nrStats]uint64{}, [nrUserStats]uint64{})
+	seen := s.kernel[statSyscalls]
+
+	fmt.Printf("\n📉 Bilancio perdite: %d syscall viste\n", seen)
+	if len(lines) == 0 {
+		fmt.Println("   ✅ Nessun evento o stack perso")
+		return nil
+	}
+	for _, line := range lines {
+		fmt.Printf("   %s\n", line)
+	}
+	s.warn(lost, seen)
+	return nil
+}
+
+// PrintKernelLosses stampa solo i contatori del kernel indicati, se non sono nulli.
+// Serve ai sottocomandi di profiling, che non vedono syscall e non hanno un bilancio completo
+func (s *LossStats) PrintKernelLosses(idx ...int) error {
+	if err := s.read(); err != nil {
+		return err
+	}
+	for _, i := range idx {
+		if n := s.kernel[i]; n > 0 {
+			fmt.Printf("\n⚠️  %d %s\n", n, statNames[i])
+		}
+	}
+	return nil
+}
+
+// warn avvisa se la percentuale di syscall perse supera la soglia
+func (s *LossStats) warn(lost, seen uint64) {
+	if seen == 0 || lost == 0 {
+		return
+	}
+	rate := float64(lost) / float64(seen) * 100
+	if rate > s.threshold {
+		fmt.Printf("   ⚠️  Perso il %.2f%% degli eventi (soglia %.2f%%): aumentare il ring buffer o usare --syscalls/--aggregate\n", rate, s.threshold)
+	}
+}
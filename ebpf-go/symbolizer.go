@@ -2,13 +2,15 @@ package main
 
 import (
 	"bufio"
-	"debug/elf"
 	"fmt"
+	"io"
 	"os"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
-
-	"github.com/ianlancetaylor/demangle"
+	"syscall"
+	"time"
 )
 
 /*
@@ -19,11 +21,13 @@ e cercare nella sua tabella dei simboli.
 
 // MemoryRegion rappresenta una riga di /proc/<PID>/maps, contiene quali file binari sono stati caricati e dove sono
 // Per risolvere i simboli del codice nativo (binario node e librerie di sistema)
+// e per classificare gli indirizzi dei dati (le regioni anonime hanno Path vuoto)
 // ES: 7f8a9b000000-7f8a9b200000 r-xp 00000000 08:01 123456 /usr/lib/libc.so.6
 type MemoryRegion struct {
 	Start  uint64
 	End    uint64
 	Offset uint64
+	Perms  string // es. "r-xp"
 	Path   string
 }
 
@@ -36,25 +40,52 @@ type JITSymbol struct {
 }
 
 type Symbolizer struct {
-	pid        int                  //Per costruire i percorsi dei file da leggere (es. /proc/1234/maps e /tmp/perf-1234.map).
-	regions    []MemoryRegion       //Contiene le mappe delle librerie C/C++
-	jitSymbols []JITSymbol          //Contiene le funzioni javascript JIT
-	elfCache   map[string]*elf.File //Salva nella mappa i file ELF aperti per accesso veloce (come una cache)
-	symCache   map[uint64]string    // MODIFICARE:Cache per non ricalcolare IP solo per funzioni C/C++ (Oppure togliere)
+	pid        int                    //Per costruire i percorsi dei file da leggere (es. /proc/1234/maps e /tmp/perf-1234.map).
+	regions    []MemoryRegion         //Contiene le mappe delle librerie C/C++
+	jitSymbols []JITSymbol            //Contiene le funzioni javascript JIT
+	jitMaxEnd  []uint64               //jitMaxEnd[i] è la End più alta tra jitSymbols[0..i], per la ricerca binaria
+	elfCache   map[string]*ELFSymbols //Indici dei simboli dei file ELF già letti (nil se il file non è leggibile)
+	symCache   map[uint64]string      // MODIFICARE:Cache per non ricalcolare IP solo per funzioni C/C++ (Oppure togliere)
+
+	mapsDirty    bool      //Il kernel ha segnalato nuovo codice mappato (dlopen, addon .node): regions va riletta
+	mapsLoadedAt time.Time //Ultima lettura di /proc/<PID>/maps, per non rileggerla troppo spesso
+
+	perfMapOffset int64     //Byte della perf-map già letti: il file cresce soltanto, rileggiamo solo la coda
+	perfMapIno    uint64    //Inode della perf-map letta, per accorgerci se è stata ricreata
+	perfMapReadAt time.Time //Ultima lettura della perf-map
+
+	generation uint64 //Incrementata quando nomi già restituiti possono non valere più (es. per StackTable)
 }
 
+// Intervallo minimo tra due letture di /proc/<PID>/maps dello stesso processo
+const mapsRefreshInterval = time.Second
+
+// Intervallo minimo tra due letture della perf-map richieste da un indirizzo JIT senza funzione
+const perfMapRetryInterval = 100 * time.Millisecond
+
 // Costruttore dell'oggetto symbolizer, restituisce un puntatore allla struct
 func NewSymbolizer(pid int) *Symbolizer {
 	sym := &Symbolizer{
 		pid:      pid,
-		elfCache: make(map[string]*elf.File), //con make alloca lo spazio per le due mappe
+		elfCache: make(map[string]*ELFSymbols), //con make alloca lo spazio per le due mappe
 		symCache: make(map[uint64]string),
 	}
 	sym.loadProcMaps() //chiamo i due metodi per riempire gli array delle funzioni C/C++ e JS
-	sym.loadPerfMap()
+	sym.updatePerfMap()
 	return sym
 }
 
+// elfSymbols restituisce l'indice dei simboli di un file, ricordando anche i file non leggibili
+// (es. cancellati dopo un aggiornamento) per non riprovare a ogni frame
+func (s *Symbolizer) elfSymbols(path string) *ELFSymbols {
+	idx, ok := s.elfCache[path]
+	if !ok {
+		idx, _ = loadELFSymbols(path)
+		s.elfCache[path] = idx
+	}
+	return idx
+}
+
 // 1. Carica la mappa della memoria di Linux
 // Il file /proc/<PID>/maps contiene l'elenco esatto di dove sono posizionate le librerie
 // (come libc o il binario di node) nella memoria RAM.
@@ -62,19 +93,30 @@ func NewSymbolizer(pid int) *Symbolizer {
 func (s *Symbolizer) loadProcMaps() {
 	file, err := os.Open(fmt.Sprintf("/proc/%d/maps", s.pid))
 	if err != nil {
-		return
+		return // Processo terminato: teniamo le regioni che avevamo
 	}
 	defer file.Close()
 
+	// Per svuotare la lista prima di aggiornarla
+	oldRegions := s.regions
+	s.regions = nil
+	s.mapsLoadedAt = time.Now()
+	s.mapsDirty = false
+
 	//Lo scanner legge il file riga per riga.
 	scanner := bufio.NewScanner(file)
 	//ES: 7f8a9b000000-7f8a9b200000 r-xp 00000000 08:01 123456 /usr/lib/libc.so.6
 	//Prende questa riga e la taglia in un array di "parole" usando gli spazi vuoti come separatore.
-	//Poiché a noi interessano solo i file binari da analizzare (ELF), se la riga ha meno di 6 colonne la ignoriamo e passiamo alla successiva
+	//Le regioni anonime (heap di V8, codice JIT, malloc) hanno solo 5 colonne: le teniamo con
+	//Path vuoto, servono a classificare gli indirizzi delle page fault, mentre Resolve le salta
 	for scanner.Scan() {
 		fields := strings.Fields(scanner.Text())
-		if len(fields) < 6 {
-			continue // Ignoriamo le regioni di memoria anonime senza percorso
+		if len(fields) < 5 {
+			continue
+		}
+		path := ""
+		if len(fields) >= 6 {
+			path = fields[5]
 		}
 		//Prende la prima colonna (es. 7f8a9b000000-7f8a9b200000) e la divide a metà usando il trattino (-).
 		addrs := strings.Split(fields[0], "-")
@@ -84,39 +126,194 @@ func (s *Symbolizer) loadProcMaps() {
 		offset, _ := strconv.ParseUint(fields[2], 16, 64)
 		//Creo la struttura dati memoryRegion e la appendo nell'array
 		s.regions = append(s.regions, MemoryRegion{
-			Start: start, End: end, Offset: offset, Path: fields[5],
+			Start: start, End: end, Offset: offset, Perms: fields[1], Path: path,
 		})
 	}
+	//Il kernel elenca già le regioni in ordine di indirizzo, ma Region fa una ricerca binaria: meglio esserne certi
+	sort.Slice(s.regions, func(i, j int) bool { return s.regions[i].Start < s.regions[j].Start })
+
+	// Dopo un dlclose e un dlopen lo stesso intervallo può contenere un'altra libreria:
+	// le risposte in cache per il codice nativo non valgono più
+	if oldRegions != nil && !slices.Equal(fileRegions(oldRegions), fileRegions(s.regions)) {
+		for ip, name := range s.symCache {
+			if !strings.HasPrefix(name, "[JS] ") {
+				delete(s.symCache, ip)
+			}
+		}
+		s.generation++
+	}
 }
 
-// 2. Carica la mappa delle funzioni JIT di Node.js (JavaScript)
-func (s *Symbolizer) loadPerfMap() {
+// fileRegions restituisce solo le regioni mappate da un file: le uniche da cui dipendono i
+// nomi del codice nativo (heap e memoria anonima cambiano di continuo)
+func fileRegions(regions []MemoryRegion) []MemoryRegion {
+	var files []MemoryRegion
+	for _, region := range regions {
+		if strings.HasPrefix(region.Path, "/") {
+			files = append(files, region)
+		}
+	}
+	return files
+}
 
-	// Per svuotare la lista prima di aggiornarla
-	s.jitSymbols = nil
+// Generation cambia ogni volta che Resolve può dare per un indirizzo un nome diverso da prima
+func (s *Symbolizer) Generation() uint64 {
+	return s.generation
+}
+
+// MarkMapsDirty segna che il processo ha mappato nuovo codice: le regioni verranno rilette
+// alla prossima risoluzione
+func (s *Symbolizer) MarkMapsDirty() {
+	s.mapsDirty = true
+}
+
+// refreshProcMaps rilegge /proc/<PID>/maps, ma non più di una volta ogni mapsRefreshInterval.
+// La cache dei file ELF resta: le librerie già indicizzate non vengono rilette
+func (s *Symbolizer) refreshProcMaps() bool {
+	if time.Since(s.mapsLoadedAt) < mapsRefreshInterval {
+		return false
+	}
+	s.loadProcMaps()
+	return true
+}
+
+// 2. Legge le nuove funzioni JIT di Node.js (JavaScript) da /tmp/perf-<PID>.map.
+// V8 aggiunge solo righe in fondo al file, che può arrivare a centinaia di MB: leggiamo i byte
+// dopo l'ultima lettura e inseriamo le nuove funzioni nell'indice già ordinato.
+// Restituisce true se l'indice è cambiato
+func (s *Symbolizer) updatePerfMap() bool {
+	s.perfMapReadAt = time.Now()
 
 	file, err := os.Open(fmt.Sprintf("/tmp/perf-%d.map", s.pid))
 	if err != nil {
-		return // Node non è stato avviato con --perf-basic-prof
+		return false // Node non è stato avviato con --perf-basic-prof
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	var ino uint64
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		ino = st.Ino
+	}
+
+	// File ricreato (PID riusato da un nuovo processo) o troncato: ripartiamo da zero
+	changed := false
+	if ino != s.perfMapIno || info.Size() < s.perfMapOffset {
+		changed = len(s.jitSymbols) > 0
+		s.jitSymbols, s.jitMaxEnd = nil, nil
+		s.perfMapIno, s.perfMapOffset = ino, 0
+	}
+	if _, err := file.Seek(s.perfMapOffset, io.SeekStart); err != nil {
+		return changed
+	}
+
+	var added []JITSymbol
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break // Ultima riga incompleta (V8 la sta scrivendo): la rileggiamo la prossima volta
+		}
+		s.perfMapOffset += int64(len(line))
+
 		// Formato: <indirizzo_esadecimale> <dimensione_esadecimale> <NomeFunzione>
 		//Taglia la stringa al primo spazio, poi al secondo spazio, e tutto il resto che avanza lascialo intatto nel terzo pezzo,
 		// a prescindere da quanti spazi contenga".
-		parts := strings.SplitN(scanner.Text(), " ", 3)
+		parts := strings.SplitN(strings.TrimSuffix(line, "\n"), " ", 3)
 		if len(parts) < 3 {
 			continue
 		}
 		start, _ := strconv.ParseUint(parts[0], 16, 64)
 		size, _ := strconv.ParseUint(parts[1], 16, 64)
 
-		s.jitSymbols = append(s.jitSymbols, JITSymbol{
+		added = append(added, JITSymbol{
 			Start: start, End: start + size, Name: parts[2],
 		})
 	}
+
+	if changed {
+		// Le risposte in cache erano del processo precedente
+		s.symCache = make(map[uint64]string)
+		s.generation++
+	}
+	if len(added) == 0 {
+		return changed
+	}
+	s.mergeJITSymbols(added)
+	return true
+}
+
+// mergeJITSymbols inserisce le nuove funzioni nell'indice ordinato per indirizzo. V8 riusa la
+// memoria del codice, quindi due voci della perf-map possono sovrapporsi: a parità di indirizzo
+// le nuove vanno dopo le vecchie, come se avessimo ordinato in modo stabile tutto il file,
+// e jitMaxEnd permette di fermare la ricerca all'indietro
+func (s *Symbolizer) mergeJITSymbols(added []JITSymbol) {
+	sort.SliceStable(added, func(i, j int) bool { return added[i].Start < added[j].Start })
+
+	merged := make([]JITSymbol, 0, len(s.jitSymbols)+len(added))
+	i, j := 0, 0
+	for i < len(s.jitSymbols) && j < len(added) {
+		if added[j].Start < s.jitSymbols[i].Start {
+			merged = append(merged, added[j])
+			j++
+		} else {
+			merged = append(merged, s.jitSymbols[i])
+			i++
+		}
+	}
+	merged = append(merged, s.jitSymbols[i:]...)
+	s.jitSymbols = append(merged, added[j:]...)
+
+	s.jitMaxEnd = make([]uint64, len(s.jitSymbols))
+	var maxEnd uint64
+	for i, jit := range s.jitSymbols {
+		maxEnd = max(maxEnd, jit.End)
+		s.jitMaxEnd[i] = maxEnd
+	}
+
+	// Le risposte in cache per gli indirizzi coperti dalle nuove funzioni non valgono più
+	// (codice JIT ricompilato nella stessa memoria), e neanche gli stack che le contengono
+	for ip, name := range s.symCache {
+		if jit, ok := s.findJIT(ip); ok && name != formatJIT(jit) {
+			delete(s.symCache, ip)
+			s.generation++
+		}
+	}
+}
+
+// Suffisso dei frame che Resolve non è riuscito a risolvere
+const unknownFrame = "[Sconosciuto]"
+
+// IsUnresolved dice se un nome restituito da Resolve è un indirizzo sconosciuto, che
+// potrebbe risolversi più avanti (funzione JIT non ancora scritta nella perf-map)
+func IsUnresolved(name string) bool {
+	return strings.HasSuffix(name, " "+unknownFrame)
+}
+
+// formatJIT è il nome di una funzione JavaScript negli stack, es. "[JS] LazyCompile:*app.get /var/www/app.js"
+func formatJIT(jit JITSymbol) string {
+	return "[JS] " + jit.Name
+}
+
+// inJITRegion dice se addr è in una regione anonima eseguibile, dove V8 mette il codice compilato
+func (s *Symbolizer) inJITRegion(addr uint64) bool {
+	region, ok := s.Region(addr)
+	return ok && region.Path == "" && strings.Contains(region.Perms, "x")
+}
+
+// findJIT cerca la funzione JIT che contiene ip: tra quelle che partono prima di ip,
+// la più vicina (quindi la più interna o la più recente) che lo contiene
+func (s *Symbolizer) findJIT(ip uint64) (JITSymbol, bool) {
+	i := sort.Search(len(s.jitSymbols), func(i int) bool { return s.jitSymbols[i].Start > ip })
+	for i--; i >= 0 && s.jitMaxEnd[i] > ip; i-- {
+		if ip < s.jitSymbols[i].End {
+			return s.jitSymbols[i], true
+		}
+	}
+	return JITSymbol{}, false
 }
 
 // 3. LA FUNZIONE PRINCIPALE: Traduce l'indirizzo esadecimale in una stringa leggibile
@@ -126,67 +323,70 @@ func (s *Symbolizer) Resolve(ip uint64) string {
 		return name
 	}
 
-	result := fmt.Sprintf("0x%x [Sconosciuto]", ip) // Fallback di default
+	unknown := fmt.Sprintf("0x%x %s", ip, unknownFrame)
+	result := unknown // Fallback di default
 
 	// A) Cerchiamo se è una funzione JavaScript JIT
-	for _, jit := range s.jitSymbols {
-		if ip >= jit.Start && ip < jit.End {
-			result = fmt.Sprintf("[JS] %s", jit.Name)
-			s.symCache[ip] = result
-			return result
-		}
+	// Un indirizzo nel codice JIT senza funzione è stato compilato dopo l'ultima lettura
+	// della perf-map: leggiamo subito le righe nuove invece di aspettare il prossimo giro
+	jit, ok := s.findJIT(ip)
+	if !ok && s.inJITRegion(ip) && time.Since(s.perfMapReadAt) >= perfMapRetryInterval && s.updatePerfMap() {
+		jit, ok = s.findJIT(ip)
+	}
+	if ok {
+		result = formatJIT(jit)
+		s.symCache[ip] = result
+		return result
 	}
 
 	// B) Cerchiamo se è in una libreria nativa C/C++
-	for _, region := range s.regions {
-		if ip >= region.Start && ip < region.End {
-			// Calcoliamo l'offset relativo all'interno del file ELF
-			fileOffset := ip - region.Start + region.Offset
-
-			// Apriamo il file ELF solo se non l'abbiamo già aperto
-			elfFile, ok := s.elfCache[region.Path]
-			if !ok {
-				var err error
-				elfFile, err = elf.Open(region.Path)
-				if err == nil {
-					s.elfCache[region.Path] = elfFile
-				}
-			}
+	// Se il kernel ha segnalato nuovo codice, o se l'indirizzo non è in nessuna regione
+	// (libreria caricata dopo l'ultima lettura), rileggiamo prima le mappe
+	if s.mapsDirty {
+		s.refreshProcMaps()
+	}
+	region, ok := s.Region(ip)
+	if !ok && s.refreshProcMaps() {
+		region, ok = s.Region(ip)
+	}
+	// Le regioni anonime non hanno un file ELF in cui cercare
+	if ok && region.Path != "" {
+		// Calcoliamo l'offset relativo all'interno del file ELF: l'indice lo traduce
+		// nell'indirizzo virtuale dei simboli tramite i segmenti PT_LOAD
+		fileOffset := ip - region.Start + region.Offset
 
-			if elfFile != nil {
-				// Leggiamo i simboli (sia quelli standard che quelli dinamici .so)
-				symbols, _ := elfFile.Symbols()
-				dynSymbols, _ := elfFile.DynamicSymbols()
-				symbols = append(symbols, dynSymbols...)
-
-				for _, sym := range symbols {
-					// Attenzione: per i file condivisi (.so), sym.Value è l'offset dal base address
-					if fileOffset >= sym.Value && fileOffset < sym.Value+sym.Size {
-						// Trovato! Estrarre solo il nome base del file (es. libc.so.6)
-						libName := region.Path[strings.LastIndex(region.Path, "/")+1:]
-
-						// Usiamo NoParams per rimuovere i lunghi argomenti delle funzioni C++ e tenere solo il nome
-						demangledName, err := demangle.ToString(sym.Name, demangle.NoParams)
-						if err != nil {
-							// Se il demangling fallisce (ad esempio se è una funzione C standard come "__open64"
-							// che non è mangled), teniamo semplicemente il nome originale.
-							demangledName = sym.Name
-						}
-
-						result = fmt.Sprintf("[C/C++] %s (%s)", demangledName, libName)
-						break
-					}
-				}
-			}
-			// Se non trova il simbolo nell'ELF, stampa almeno il nome della libreria
-			if result == fmt.Sprintf("0x%x [Sconosciuto]", ip) {
-				libName := region.Path[strings.LastIndex(region.Path, "/")+1:]
-				result = fmt.Sprintf("0x%x [%s]", ip, libName)
-			}
-			break
+		// L'indice dei simboli del file viene costruito una volta sola (e condiviso tra i processi)
+		if name, ok := s.elfSymbols(region.Path).LookupOffset(fileOffset); ok {
+			// Trovato! Estrarre solo il nome base del file (es. libc.so.6)
+			libName := region.Path[strings.LastIndex(region.Path, "/")+1:]
+			result = fmt.Sprintf("[C/C++] %s (%s)", name, libName)
+		}
+		// Se non trova il simbolo nell'ELF, stampa almeno il nome della libreria
+		if result == unknown {
+			libName := region.Path[strings.LastIndex(region.Path, "/")+1:]
+			result = fmt.Sprintf("0x%x [%s]", ip, libName)
 		}
 	}
 
-	s.symCache[ip] = result
+	// Gli sconosciuti non vanno in cache: la libreria o la funzione JIT potrebbero arrivare dopo
+	if result != unknown {
+		s.symCache[ip] = result
+	}
 	return result
 }
+
+// Region restituisce la regione di memoria (anche anonima) che contiene addr.
+// Le regioni di /proc/<PID>/maps non si sovrappongono: basta una ricerca binaria
+func (s *Symbolizer) Region(addr uint64) (MemoryRegion, bool) {
+	i := sort.Search(len(s.regions), func(i int) bool { return s.regions[i].Start > addr })
+	if i == 0 || addr >= s.regions[i-1].End {
+		return MemoryRegion{}, false
+	}
+	return s.regions[i-1], true
+}
+
+// IsJIT dice se addr è dentro una funzione JavaScript compilata della perf-map
+func (s *Symbolizer) IsJIT(addr uint64) bool {
+	_, ok := s.findJIT(addr)
+	return ok
+}
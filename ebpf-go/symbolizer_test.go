@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestSymbolizer costruisce un Symbolizer senza leggere /proc né la perf-map:
+// le letture risultano appena fatte, così Resolve non prova a rileggerle
+func newTestSymbolizer(regions []MemoryRegion, jits []JITSymbol) *Symbolizer {
+	s := &Symbolizer{
+		pid:           -1,
+		regions:       regions,
+		elfCache:      make(map[string]*ELFSymbols),
+		symCache:      make(map[uint64]string),
+		mapsLoadedAt:  time.Now(),
+		perfMapReadAt: time.Now(),
+	}
+	if len(jits) > 0 {
+		s.mergeJITSymbols(jits)
+	}
+	return s
+}
+
+func TestFindJIT(t *testing.T) {
+	s := newTestSymbolizer(nil, []JITSymbol{
+		{Start: 0x1000, End: 0x9000, Name: "outer"}, // Copre tutte le funzioni seguenti
+		{Start: 0x2000, End: 0x2100, Name: "a"},
+		{Start: 0x2040, End: 0x2060, Name: "a-inner"},
+		{Start: 0x3000, End: 0x3100, Name: "old"},
+		{Start: 0x9000, End: 0x9100, Name: "adjacent"},
+	})
+	// Codice JIT ricompilato nella stessa memoria: la voce più recente vince
+	s.mergeJITSymbols([]JITSymbol{{Start: 0x3000, End: 0x3080, Name: "new"}})
+
+	tests := []struct {
+		ip   uint64
+		want string // "" = nessuna funzione
+	}{
+		{0x0fff, ""},
+		{0x1000, "outer"},
+		{0x2000, "a"},
+		{0x2040, "a-inner"},
+		{0x205f, "a-inner"},
+		{0x2060, "a"},
+		{0x2100, "outer"}, // Dopo la fine di "a" resta la funzione che la contiene
+		{0x3000, "new"},
+		{0x307f, "new"},
+		{0x3080, "old"}, // Oltre la voce nuova la vecchia copre ancora
+		{0x3100, "outer"},
+		{0x8fff, "outer"},
+		{0x9000, "adjacent"}, // End esclusiva: 0x9000 non è più in "outer"
+		{0x9100, ""},
+	}
+	for _, tt := range tests {
+		// Senza funzione il nome resta vuoto
+		if jit, ok := s.findJIT(tt.ip); jit.Name != tt.want {
+			t.Errorf("findJIT(0x%x) = %q, %v; want %q", tt.ip, jit.Name, ok, tt.want)
+		}
+	}
+}
+
+func TestFindJITReuseInvalidatesCache(t *testing.T) {
+	regions := []MemoryRegion{{Start: 0x1000, End: 0x10000, Perms: "rwxp"}}
+	s := newTestSymbolizer(regions, []JITSymbol{{Start: 0x2000, End: 0x2100, Name: "before"}})
+
+	if got, want := s.Resolve(0x2010), "[JS] before"; got != want {
+		t.Fatalf("Resolve = %q, want %q", got, want)
+	}
+	gen := s.Generation()
+
+	// Funzioni nuove in altri indirizzi non cambiano le risposte già date
+	s.mergeJITSymbols([]JITSymbol{{Start: 0x3000, End: 0x3100, Name: "other"}})
+	if s.Generation() != gen {
+		t.Errorf("Generation cambiata senza riuso del codice")
+	}
+
+	s.mergeJITSymbols([]JITSymbol{{Start: 0x2000, End: 0x2100, Name: "after"}})
+	if got, want := s.Resolve(0x2010), "[JS] after"; got != want {
+		t.Errorf("Resolve dopo il riuso = %q, want %q", got, want)
+	}
+	if s.Generation() == gen {
+		t.Errorf("Generation invariata dopo il riuso: gli stack in cache resterebbero vecchi")
+	}
+}
+
+func TestResolveUnknown(t *testing.T) {
+	regions := []MemoryRegion{{Start: 0x1000, End: 0x10000, Perms: "rwxp"}}
+	s := newTestSymbolizer(regions, nil)
+	if name := s.Resolve(0x2010); !IsUnresolved(name) {
+		t.Errorf("Resolve senza funzioni = %q, want sconosciuto", name)
+	}
+	s.mergeJITSymbols([]JITSymbol{{Start: 0x2000, End: 0x2100, Name: "late"}})
+	if got, want := s.Resolve(0x2010), "[JS] late"; got != want {
+		t.Errorf("Resolve dopo la perf-map = %q, want %q", got, want)
+	}
+}
+
+func TestRegion(t *testing.T) {
+	s := newTestSymbolizer([]MemoryRegion{
+		{Start: 0x1000, End: 0x2000, Path: "first"},
+		{Start: 0x2000, End: 0x3000, Path: "second"},
+		{Start: 0x5000, End: 0x6000, Path: ""},
+	}, nil)
+
+	tests := []struct {
+		addr uint64
+		want string
+		ok   bool
+	}{
+		{0x0fff, "", false},
+		{0x1000, "first", true}, // Start inclusa
+		{0x1fff, "first", true},
+		{0x2000, "second", true}, // End esclusiva: appartiene alla regione seguente
+		{0x2fff, "second", true},
+		{0x3000, "", false},
+		{0x4fff, "", false},
+		{0x5000, "", true}, // Regione anonima
+		{0x6000, "", false},
+	}
+	for _, tt := range tests {
+		region, ok := s.Region(tt.addr)
+		if ok != tt.ok || region.Path != tt.want {
+			t.Errorf("Region(0x%x) = %q, %v; want %q, %v", tt.addr, region.Path, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// BenchmarkResolve misura il costo di un frame con una perf-map di un processo Node grande
+// e molte librerie mappate: a cache vuota (ogni indirizzo risolto per la prima volta) e già piena
+func BenchmarkResolve(b *testing.B) {
+	const (
+		nJIT     = 50000
+		nRegions = 2000
+		jitBase  = 0x10000000
+		libBase  = 0x7f0000000000
+	)
+	var jits []JITSymbol
+	for i := 0; i < nJIT; i++ {
+		start := uint64(jitBase + i*0x400)
+		jits = append(jits, JITSymbol{Start: start, End: start + 0x300, Name: fmt.Sprintf("LazyCompile:*fn%d app.js:%d", i, i)})
+	}
+	// Ogni libreria ha una regione di testo e una di dati, più l'area anonima del codice JIT
+	regions := []MemoryRegion{{Start: jitBase, End: jitBase + nJIT*0x400, Perms: "rwxp"}}
+	for i := 0; i < nRegions; i++ {
+		start := uint64(libBase + i*0x20000)
+		path := fmt.Sprintf("/nonexistent/lib%d.so", i)
+		regions = append(regions,
+			MemoryRegion{Start: start, End: start + 0x10000, Perms: "r-xp", Path: path},
+			MemoryRegion{Start: start + 0x10000, End: start + 0x18000, Offset: 0x10000, Perms: "rw-p", Path: path})
+	}
+
+	// Stack tipici: frame JIT e frame nativi mescolati
+	ips := make([]uint64, 4096)
+	for i := range ips {
+		if i%2 == 0 {
+			ips[i] = uint64(jitBase + (i*7919%nJIT)*0x400 + 0x10)
+		} else {
+			ips[i] = uint64(libBase + (i*104729%nRegions)*0x20000 + 0x100)
+		}
+	}
+
+	b.Run("cold", func(b *testing.B) {
+		s := newTestSymbolizer(regions, jits)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if i%len(ips) == 0 {
+				s.symCache = make(map[uint64]string)
+			}
+			s.Resolve(ips[i%len(ips)])
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		s := newTestSymbolizer(regions, jits)
+		for _, ip := range ips {
+			s.Resolve(ip)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			s.Resolve(ips[i%len(ips)])
+		}
+	})
+}
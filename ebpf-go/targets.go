@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+)
+
+/*
+Insieme dei processi monitorati.
+Si possono indicare più PID (cluster mode, worker di pm2) e interi cgroup (container, slice di systemd):
+in quel caso i PID non sono noti in anticipo, e creiamo il Symbolizer alla prima occorrenza di ogni processo.
+*/
+
+// Radice del filesystem dei cgroup v2
+const cgroupRoot = "/sys/fs/cgroup"
+
+type TargetSet struct {
+	pids        map[uint32]bool        // PID presenti in target_pid_map (utente e figli)
+	cgroups     []string               // Cgroup indicati dall'utente (solo per i messaggi)
+	symbolizers map[uint32]*Symbolizer // Un Symbolizer per ogni processo visto negli eventi
+	pidMap      *ebpf.Map
+	cgroupMap   *ebpf.Map
+}
+
+func NewTargetSet(pidMap, cgroupMap *ebpf.Map) *TargetSet {
+	return &TargetSet{
+		pids:        make(map[uint32]bool),
+		symbolizers: make(map[uint32]*Symbolizer),
+		pidMap:      pidMap,
+		cgroupMap:   cgroupMap,
+	}
+}
+
+// AddPid inserisce un processo nella mappa eBPF dei PID.
+// Il valore 0 indica un processo scelto dall'utente (per i figli il kernel scrive il PID del padre)
+func (t *TargetSet) AddPid(pid uint32) error {
+	val := uint32(0)
+	if err := t.pidMap.Put(&pid, &val); err != nil {
+		return err
+	}
+	t.pids[pid] = true
+	return nil
+}
+
+// AddChild inserisce un discendente di un processo monitorato, come farebbe il kernel alla fork
+func (t *TargetSet) AddChild(pid, ppid uint32) error {
+	if err := t.pidMap.Put(&pid, &ppid); err != nil {
+		return err
+	}
+	t.pids[pid] = true
+	return nil
+}
+
+// Track registra un processo che il kernel ha già inserito in target_pid_map (fork)
+func (t *TargetSet) Track(pid uint32) {
+	t.pids[pid] = true
+}
+
+// ExistingChildren restituisce i discendenti già vivi di un processo (es. i worker
+// di cluster avviati prima del tracer), leggendo /proc/<PID>/task/*/children
+func ExistingChildren(pid uint32) []uint32 {
+	var children []uint32
+	files, _ := filepath.Glob(fmt.Sprintf("/proc/%d/task/*/children", pid))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		for _, field := range strings.Fields(string(data)) {
+			child, err := strconv.ParseUint(field, 10, 32)
+			if err != nil {
+				continue
+			}
+			children = append(children, uint32(child))
+			children = append(children, ExistingChildren(uint32(child))...)
+		}
+	}
+	return children
+}
+
+// AddCgroup inserisce un cgroup v2 nella mappa eBPF. Il percorso può essere assoluto
+// (/sys/fs/cgroup/system.slice/app.service) o relativo alla radice (system.slice/app.service).
+// L'id del cgroup che il kernel restituisce con bpf_get_current_cgroup_id è il numero di inode della sua directory.
+func (t *TargetSet) AddCgroup(path string) error {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cgroupRoot, path)
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return fmt.Errorf("cgroup %s: %w", path, err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		return fmt.Errorf("cgroup %s: non è una directory", path)
+	}
+	id := st.Ino
+	val := uint32(1)
+	if err := t.cgroupMap.Put(&id, &val); err != nil {
+		return err
+	}
+	t.cgroups = append(t.cgroups, path)
+	return nil
+}
+
+// HasCgroups dice se il kernel deve controllare anche il cgroup dei processi
+func (t *TargetSet) HasCgroups() bool {
+	return len(t.cgroups) > 0
+}
+
+// Symbolizer restituisce il Symbolizer del processo, creandolo alla prima richiesta
+func (t *TargetSet) Symbolizer(pid uint32) *Symbolizer {
+	symb, ok := t.symbolizers[pid]
+	if !ok {
+		symb = NewSymbolizer(int(pid))
+		t.symbolizers[pid] = symb
+	}
+	return symb
+}
+
+// PrepareSymbolizers crea subito il Symbolizer dei PID già noti (indicati dall'utente e figli vivi),
+// mentre il processo esiste ancora; quelli dei cgroup nascono alla prima occorrenza
+func (t *TargetSet) PrepareSymbolizers() {
+	for pid := range t.pids {
+		t.Symbolizer(pid)
+	}
+}
+
+// ResetSymbolizer scarta il Symbolizer del processo: dopo un exec le sue mappe di memoria
+// non valgono più, e il nuovo verrà creato al prossimo evento
+func (t *TargetSet) ResetSymbolizer(pid uint32) {
+	delete(t.symbolizers, pid)
+}
+
+// Forget libera lo stato di un processo terminato (il kernel lo ha già tolto da target_pid_map)
+func (t *TargetSet) Forget(pid uint32) {
+	t.ResetSymbolizer(pid)
+	delete(t.pids, pid)
+}
+
+// IsTraced dice se il processo è monitorato: indicato dall'utente o già visto negli eventi
+func (t *TargetSet) IsTraced(pid uint32) bool {
+	_, seen := t.symbolizers[pid]
+	return t.pids[pid] || seen
+}
+
+// AllExited dice se tutti i processi monitorati sono terminati. Con i cgroup non è mai vero:
+// un nuovo processo nel cgroup diventa un target
+func (t *TargetSet) AllExited() bool {
+	return len(t.cgroups) == 0 && len(t.pids) == 0
+}
+
+// UpdatePerfMaps legge le righe nuove delle perf-map di tutti i processi conosciuti
+func (t *TargetSet) UpdatePerfMaps() {
+	for _, symb := range t.symbolizers {
+		symb.updatePerfMap()
+	}
+}
+
+// MarkMapsDirty segnala che il processo ha mappato nuovo codice. Se non ha ancora un
+// Symbolizer non serve: leggerà le mappe quando verrà creato
+func (t *TargetSet) MarkMapsDirty(pid uint32) {
+	if symb, ok := t.symbolizers[pid]; ok {
+		symb.MarkMapsDirty()
+	}
+}
+
+// ReloadProcMaps rilegge le mappe di memoria di tutti i processi conosciuti
+func (t *TargetSet) ReloadProcMaps() {
+	for _, symb := range t.symbolizers {
+		symb.loadProcMaps()
+	}
+}
+
+// String descrive i target per il messaggio di avvio
+func (t *TargetSet) String() string {
+	pids := make([]string, 0, len(t.pids))
+	for pid := range t.pids {
+		pids = append(pids, fmt.Sprint(pid))
+	}
+	sort.Strings(pids)
+
+	var parts []string
+	if len(pids) > 0 {
+		parts = append(parts, "PID "+strings.Join(pids, ", "))
+	}
+	if len(t.cgroups) > 0 {
+		parts = append(parts, "cgroup "+strings.Join(t.cgroups, ", "))
+	}
+	return strings.Join(parts, " e ")
+}
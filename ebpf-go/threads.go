@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+Etichette dei thread di Node.js.
+Un processo Node non è single-thread: oltre all'event loop ci sono i worker del threadpool
+di libuv (fs, dns, crypto...), i thread della piattaforma V8 (GC, compilazione) e l'inspector.
+Dal nome del thread (/proc/<PID>/task/<TID>/comm) ricaviamo un'etichetta leggibile.
+*/
+
+// ThreadLabeler assegna e ricorda l'etichetta di ogni thread
+type ThreadLabeler struct {
+	labels  map[uint32]map[uint32]string // pid -> tid -> etichetta
+	workers map[uint32]map[string]int    // pid -> tipo di worker -> ultimo numero assegnato
+}
+
+func NewThreadLabeler() *ThreadLabeler {
+	return &ThreadLabeler{
+		labels:  make(map[uint32]map[uint32]string),
+		workers: make(map[uint32]map[string]int),
+	}
+}
+
+// threadKind riconosce il tipo di thread dal suo comm (al massimo 15 caratteri, es. "V8 DefaultWorke")
+func threadKind(comm string) (kind string, numbered bool) {
+	switch {
+	case comm == "libuv-worker":
+		return "libuv-worker", true
+	case strings.HasPrefix(comm, "V8 DefaultWork"):
+		return "V8 DefaultWorker", true
+	case strings.HasPrefix(comm, "V8 DelayedTask"):
+		return "V8 DelayedTaskScheduler", false
+	case strings.Contains(strings.ToLower(comm), "inspector"):
+		return "inspector", false
+	}
+	return comm, false
+}
+
+// refresh legge i comm di tutti i thread del processo e assegna le etichette mancanti.
+// I worker vengono numerati in ordine di TID, cioè (di solito) in ordine di creazione.
+func (l *ThreadLabeler) refresh(pid uint32) {
+	if l.labels[pid] == nil {
+		l.labels[pid] = make(map[uint32]string)
+		l.workers[pid] = make(map[string]int)
+	}
+	dirs, _ := filepath.Glob(fmt.Sprintf("/proc/%d/task/*", pid))
+	tids := make([]uint32, 0, len(dirs))
+	for _, dir := range dirs {
+		tid, err := strconv.ParseUint(filepath.Base(dir), 10, 32)
+		if err == nil {
+			tids = append(tids, uint32(tid))
+		}
+	}
+	sort.Slice(tids, func(i, j int) bool { return tids[i] < tids[j] })
+
+	for _, tid := range tids {
+		if _, ok := l.labels[pid][tid]; ok {
+			continue
+		}
+		data, err := os.ReadFile(fmt.Sprintf("/proc/%d/task/%d/comm", pid, tid))
+		if err != nil {
+			continue
+		}
+		l.labels[pid][tid] = l.assign(pid, tid, strings.TrimSpace(string(data)))
+	}
+}
+
+// assign calcola l'etichetta di un nuovo thread
+func (l *ThreadLabeler) assign(pid, tid uint32, comm string) string {
+	if tid == pid {
+		return "main"
+	}
+	kind, numbered := threadKind(comm)
+	if !numbered {
+		return kind
+	}
+	l.workers[pid][kind]++
+	return fmt.Sprintf("%s-%d", kind, l.workers[pid][kind])
+}
+
+// Label restituisce l'etichetta del thread. Il comm arriva dal kernel insieme all'evento
+// e serve se il thread è già terminato quando leggiamo /proc.
+func (l *ThreadLabeler) Label(pid, tid uint32, comm string) string {
+	if label, ok := l.labels[pid][tid]; ok {
+		return label
+	}
+	l.refresh(pid)
+	if label, ok := l.labels[pid][tid]; ok {
+		return label
+	}
+	label := l.assign(pid, tid, comm)
+	l.labels[pid][tid] = label
+	return label
+}
+
+// Forget dimentica i thread di un processo terminato
+func (l *ThreadLabeler) Forget(pid uint32) {
+	delete(l.labels, pid)
+	delete(l.workers, pid)
+}
+
+// threadKey identifica un thread nel riepilogo
+type threadKey struct {
+	pid uint32
+	tid uint32
+}
+
+type threadStat struct {
+	label    string
+	count    int
+	duration time.Duration
+	syscalls map[string]int
+}
+
+// ThreadStats raggruppa le syscall per thread, per il riepilogo finale
+type ThreadStats struct {
+	threads map[threadKey]*threadStat
+}
+
+func NewThreadStats() *ThreadStats {
+	return &ThreadStats{threads: make(map[threadKey]*threadStat)}
+}
+
+// Add conta una syscall del thread
+func (t *ThreadStats) Add(pid, tid uint32, label string, info SyscallInfo) {
+	key := threadKey{pid, tid}
+	stat, ok := t.threads[key]
+	if !ok {
+		stat = &threadStat{label: label, syscalls: make(map[string]int)}
+		t.threads[key] = stat
+	}
+	stat.count++
+	stat.duration += time.Duration(info.DurationNs)
+	stat.syscalls[getSyscallName(info.SyscallId)]++
+}
+
+// PrintSummary stampa, per ogni processo e thread, il numero di syscall, il tempo totale
+// trascorso nel kernel e le syscall più frequenti
+func (t *ThreadStats) PrintSummary() {
+	if len(t.threads) == 0 {
+		return
+	}
+	keys := make([]threadKey, 0, len(t.threads))
+	for key := range t.threads {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].pid != keys[j].pid {
+			return keys[i].pid < keys[j].pid
+		}
+		return keys[i].tid < keys[j].tid
+	})
+
+	fmt.Println("\n🧵 Riepilogo syscall per thread:")
+	lastPid := uint32(0)
+	for _, key := range keys {
+		if key.pid != lastPid {
+			fmt.Printf("   PID %d\n", key.pid)
+			lastPid = key.pid
+		}
+		stat := t.threads[key]
+		fmt.Printf("      TID %-7d %-26s %6d syscall, %s nel kernel | %s\n",
+			key.tid, "["+stat.label+"]", stat.count, stat.duration.Round(time.Microsecond), topSyscalls(stat.syscalls, 5))
+	}
+}
+
+// topSyscalls restituisce le n syscall più frequenti, es. "read 120, epoll_wait 80"
+func topSyscalls(counts map[string]int, n int) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	if len(names) > n {
+		names = names[:n]
+	}
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s %d", name, counts[name])
+	}
+	return strings.Join(parts, ", ")
+}
@@ -2,33 +2,320 @@
 
 #include "vmlinux.h"
 #include <bpf/bpf_helpers.h>
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_tracing.h>
 
 
+// Lunghezza massima dei percorsi copiati dalla memoria utente (PATH_MAX sarebbe 4096,
+// troppo per ogni evento: i percorsi più lunghi arrivano troncati)
+#define PATH_LEN 256
+#define NO_PATH_ARG 0xff
+
+// Dimensione di struct sockaddr_storage: basta per IPv4, IPv6 e socket Unix
+#define SOCKADDR_LEN 128
+
+// Limiti della cattura di execve: argomenti e chiavi d'ambiente oltre questi vengono ignorati
+#define EXEC_MAX_ARGS 16
+#define EXEC_ARG_LEN  128
+#define EXEC_MAX_ENV  16
+#define EXEC_ENV_LEN  64
+
+// Syscall che non ritornano mai: per loro non arriverà nessun sys_exit
+#define SYS_EXECVE      59
+#define SYS_EXIT        60
+#define SYS_EXIT_GROUP  231
+#define SYS_EXECVEAT    322
+#define SYS_MMAP        9
+#define SYS_MPROTECT    10
+
+#define PROT_EXEC     0x4
+#define MAP_ANONYMOUS 0x20
+
+// Famiglie di indirizzi (include/linux/socket.h)
+#define AF_INET  2
+#define AF_INET6 10
+
+// task_struct->flags: il thread sta terminando (include/linux/sched.h)
+#define PF_EXITING 0x00000004
+
+// Tipi di record nel ring buffer: il primo campo di ogni struttura dice a Go come decodificarla
+enum event_type {
+    EVENT_SYSCALL = 1,
+    EVENT_EXEC    = 2,
+    EVENT_PROC    = 3,
+    EVENT_SYSCALL_INLINE = 4, // struct inline_event: my_syscall_info seguita dai frame dello stack
+    EVENT_SIGNAL  = 5,
+    EVENT_UPROBE  = 6, // struct my_syscall_info: syscall_id è l'id della sonda, niente percorsi e indirizzi
+    EVENT_MAPS    = 7, // struct maps_event: il processo ha mappato nuovo codice, Go rilegge /proc/<PID>/maps
+    EVENT_EXEC_RESULT = 8, // struct exec_result: esito di un exec annunciato con EVENT_EXEC
+};
+
+// Sottotipi di EVENT_PROC: ciclo di vita dei processi monitorati
+enum proc_kind {
+    PROC_FORK = 1,
+    PROC_EXEC = 2,
+    PROC_EXIT = 3,
+};
+
+// Sottotipi di EVENT_SIGNAL
+enum signal_kind {
+    SIGNAL_GENERATE = 1, // Qualcuno (un processo o il kernel) ha inviato il segnale
+    SIGNAL_DELIVER  = 2, // Il thread destinatario sta per gestirlo (handler o azione predefinita)
+};
+
 // 1. STRUTTURA PER IL RING BUFFER
-// Mettendo il dato più grande (8 byte) per primo, e i due 
-// da 4 byte dopo, raggiungiamo esattamente i 16 byte. Nessun "buco" di memoria!
+// Mettendo prima le coppie di campi da 4 byte, poi quelli da 8 byte e
+// i buffer in fondo, raggiungiamo esattamente i 760 byte. Nessun "buco" di memoria!
 struct my_syscall_info {
-    __u64 timestamp_ns; // 8 byte
+    __u32 type;         // 4 byte - EVENT_SYSCALL
     __u32 syscall_id;   // 4 byte
+    __u32 pid;          // 4 byte - processo che ha eseguito la syscall
     int   stack_id;     // 4 byte
+    __u64 timestamp_ns; // 8 byte - istante di ingresso nella syscall
+    __u64 duration_ns;  // 8 byte - tempo trascorso tra sys_enter e sys_exit
+    __s64 ret;          // 8 byte - valore di ritorno (negativo = -errno)
+    __u64 args[6];      // 48 byte - argomenti grezzi, decodificati in Go con tracefs
+    __u32 addr_len;     // 4 byte - byte validi in addr (0 = nessun indirizzo)
+    __u32 tid;          // 4 byte - thread (event loop, worker libuv, thread di V8...)
+    int   kstack_id;    // 4 byte - stack del kernel (-1 se non richiesto)
+    __u32 kstack_blocked; // 4 byte - 1 se kstack_id è lo stack in cui il thread si è bloccato
+    char  comm[16];     // 16 byte - nome del thread
+    char  path[2][PATH_LEN]; // 512 byte - percorsi passati alla syscall (rename ne ha due)
+    __u8  addr[SOCKADDR_LEN]; // 128 byte - sockaddr di connect/bind/sendto/accept
 }; 
 
-// Mappa Array per filtrare il PID
+// Record inviato all'ingresso di execve/execveat: dopo un exec riuscito la memoria
+// del vecchio programma non esiste più, quindi argv ed envp vanno letti subito.
+// Per envp inviamo i primi EXEC_ENV_LEN byte di ogni variabile: Go tiene solo la chiave.
+struct exec_event {
+    __u32 type;         // EVENT_EXEC
+    __u32 pid;          // Processo che esegue l'exec
+    __u32 ppid;         // Suo padre (il processo Node se l'exec avviene nel figlio)
+    int   stack_id;
+    __u64 timestamp_ns;
+    int   dirfd;        // Solo execveat: directory di partenza del percorso
+    __u32 argc;         // Argomenti catturati (al massimo EXEC_MAX_ARGS)
+    __u32 envc;         // Variabili d'ambiente catturate (al massimo EXEC_MAX_ENV)
+    __u32 tid;          // Thread che esegue l'exec
+    char  filename[PATH_LEN];
+    char  argv[EXEC_MAX_ARGS][EXEC_ARG_LEN];
+    char  envp[EXEC_MAX_ENV][EXEC_ENV_LEN];
+};
+
+// Esito di un exec: inviato da sched_process_exec se è riuscito, dal sys_exit se è fallito
+// (es. execvp che prova le directory del PATH e riceve ENOENT). Go stampa l'exec solo se riuscito
+struct exec_result {
+    __u32 type; // EVENT_EXEC_RESULT
+    __u32 pid;
+    __u32 tid;  // Thread che ha chiamato execve (lo stesso di exec_event)
+    int   ret;  // 0 oppure -errno
+    __u64 timestamp_ns;
+};
+
+// Per ogni syscall che riceve percorsi: quali argomenti sono puntatori a stringa
+// (NO_PATH_ARG se assente). La mappa viene riempita da Go all'avvio.
+struct path_args {
+    __u8 idx[2];
+};
+
+// Record inviato quando un processo monitorato crea un figlio, esegue un nuovo programma o termina
+struct proc_event {
+    __u32 type;         // EVENT_PROC
+    __u32 kind;         // PROC_FORK / PROC_EXEC / PROC_EXIT
+    __u32 pid;
+    __u32 ppid;         // Per PROC_FORK: il processo che ha fatto la fork
+    __u64 timestamp_ns;
+    int   exit_code;    // Per PROC_EXIT: task->exit_code (stato << 8 | segnale)
+    __u32 _pad;
+    char  comm[16];
+    char  filename[PATH_LEN]; // Per PROC_EXEC: il programma eseguito
+};
+
+// Numero massimo di frame di uno stack (come value_size di stack_map)
+#define MAX_STACK_DEPTH 127
+// Entry di stack_map: gli id vanno da 0 a STACK_MAP_SIZE-1
+#define STACK_MAP_SIZE  1024
+
+// Record a lunghezza variabile della modalità --inline-stacks: lo stack utente viaggia
+// nel record stesso, scritto da bpf_get_stack, senza passare da stack_map.
+// Nel ring buffer finiscono solo i primi nr_frames elementi di frames
+struct inline_event {
+    struct my_syscall_info info; // 760 byte, info.type = EVENT_SYSCALL_INLINE
+    __u32 nr_frames;
+    __u32 _pad;
+    __u64 frames[MAX_STACK_DEPTH];
+};
+
+// Dati salvati al sys_enter in attesa del sys_exit dello stesso thread
+struct enter_info {
+    __u64 timestamp_ns;
+    __u64 args[6];
+    __u32 syscall_id;
+    int   stack_id;
+    int   kstack_id;
+    __u32 kstack_blocked;
+};
+
+// Contatori di stats_map: quante syscall abbiamo visto e, per ogni motivo, quanti dati sono andati persi
+enum stat_counter {
+    STAT_SYSCALLS      = 0, // Syscall dei target che hanno superato il filtro
+    STAT_STACK_USER    = 1, // bpf_get_stackid fallito sullo stack utente (stack_map piena o collisione)
+    STAT_STACK_KERNEL  = 2, // bpf_get_stackid fallito sullo stack del kernel
+    STAT_RINGBUF_FULL  = 3, // Eventi syscall persi: bpf_ringbuf_reserve ha restituito NULL
+    STAT_RINGBUF_OTHER = 4, // Eventi exec/processo/segnali/mappe persi per lo stesso motivo
+    STAT_ENTER_FULL    = 5, // Ingressi non salvati in enter_map
+    STAT_AGG_FULL      = 6, // Syscall, campioni o page fault non contati perché la mappa dei contatori è piena
+    STAT_THREAD_FULL   = 7, // Stato per thread non salvato (offcpu_start_map)
+    STAT_UPROBE_LOST   = 8, // Eventi di uretprobe persi: ingresso sovrascritto (ricorsione) o ritorno senza ingresso
+    NR_STATS,
+};
+
+// Modalità del filtro sulle syscall (--syscalls / --exclude-syscalls)
+enum syscall_filter_mode {
+    FILTER_NONE  = 0, // Tutte le syscall
+    FILTER_ALLOW = 1, // Solo quelle presenti in syscall_filter_map
+    FILTER_DENY  = 2, // Tutte tranne quelle presenti in syscall_filter_map
+};
+
+// Numero di syscall coperte dal filtro (su x86_64 gli id arrivano a poco più di 460)
+#define MAX_SYSCALL_ID 512
+
+// Profondità massima delle gerarchie di cgroup che controlliamo (slice -> servizio -> container...)
+#define MAX_CGROUP_LEVELS 16
+
+// Configurazione scritta da Go all'avvio (una sola entry, chiave 0)
+struct config {
+    __u32 cgroup_filter; // 1 se in target_cgroup_map c'è almeno un cgroup
+    __u32 kernel_stacks; // 1 se oltre allo stack utente va catturato anche quello del kernel
+    __u32 syscall_filter; // enum syscall_filter_mode
+    __u32 aggregate;      // 1 = modalità aggregata: si contano le coppie (syscall, stack) in agg_map
+    __u32 inline_stacks;  // 1 = lo stack utente viaggia nel record (struct inline_event)
+};
+
 struct {
     __uint(type, BPF_MAP_TYPE_ARRAY);
     __type(key, __u32);
-    __type(value, __u32);
+    __type(value, struct config);
     __uint(max_entries, 1);
+} config_map SEC(".maps");
+
+// Mappa Hash dei PID da monitorare (cluster mode, pm2: più worker contemporaneamente)
+// Il valore è il PID del padre monitorato per i figli seguiti automaticamente, 0 per quelli scelti dall'utente
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, __u32);
+    __type(value, __u32);
+    __uint(max_entries, 4096);
 } target_pid_map SEC(".maps");
 
-// Mappa dedicata agli Stack Trace 
+// Mappa Hash dei cgroup da monitorare (container, slice di systemd): la chiave è l'id del cgroup v2
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, __u64);
+    __type(value, __u32);
+    __uint(max_entries, 64);
+} target_cgroup_map SEC(".maps");
+
+// Syscall elencate dall'utente: l'indice è il syscall_id, il valore 1 se la syscall è nella lista.
+// Un array e non una hash: la lookup costa meno ed è fatta per ogni syscall del target
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __type(key, __u32);
+    __type(value, __u8);
+    __uint(max_entries, MAX_SYSCALL_ID);
+} syscall_filter_map SEC(".maps");
+
+// Modalità aggregata: invece di un evento per syscall, un contatore per ogni
+// combinazione (processo, thread, syscall, stack). Go la svuota periodicamente
+struct agg_key {
+    __u32 pid;
+    __u32 tid;
+    __u32 syscall_id;
+    int   stack_id;
+};
+
+struct agg_value {
+    __u64 count;
+    __u64 total_ns; // Tempo totale trascorso nel kernel
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, struct agg_key);
+    __type(value, struct agg_value);
+    __uint(max_entries, 16384);
+} agg_map SEC(".maps");
+
+// Contatori per CPU: ogni CPU incrementa la propria copia senza operazioni atomiche, Go le somma
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(max_entries, NR_STATS);
+} stats_map SEC(".maps");
+
+// Mappa dedicata agli Stack Trace (sia utente che kernel: gli id sono condivisi)
 struct {
     __uint(type, BPF_MAP_TYPE_STACK_TRACE);
     __uint(key_size, sizeof(__u32));
-    __uint(value_size, 127 * sizeof(__u64));
-    __uint(max_entries, 1024);
+    __uint(value_size, MAX_STACK_DEPTH * sizeof(__u64));
+    __uint(max_entries, STACK_MAP_SIZE);
 } stack_map SEC(".maps");
 
+// Riferimenti prodotti per ogni stack_id: +1 per ogni evento che lo contiene, -1 se
+// l'evento non arriva a Go. Go conta quelli consumati e, quando i due numeri coincidono,
+// cancella lo stack da stack_map per liberare il posto. I contatori crescono soltanto:
+// dopo la cancellazione Go riparte dal valore raggiunto, senza doverli azzerare
+struct {
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(max_entries, STACK_MAP_SIZE);
+} stack_refs SEC(".maps");
+
+// Area di appoggio per costruire una inline_event: con 1784 byte non sta nello stack
+// del programma eBPF (512 byte), e bpf_ringbuf_reserve vuole una dimensione fissa
+struct {
+    __uint(type, BPF_MAP_TYPE_PERCPU_ARRAY);
+    __type(key, __u32);
+    __type(value, struct inline_event);
+    __uint(max_entries, 1);
+} inline_scratch SEC(".maps");
+
+// Mappa che accoppia sys_enter e sys_exit: la chiave è il pid_tgid (quindi il thread)
+// LRU perché se un thread muore dentro una syscall la sua entry non verrebbe mai cancellata
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, __u64);
+    __type(value, struct enter_info);
+    __uint(max_entries, 10240);
+} enter_map SEC(".maps");
+
+// Per le syscall di rete: quale argomento punta alla sockaddr e quale contiene la sua lunghezza.
+// Per accept/accept4 la lunghezza è un puntatore (socklen_t *) e l'indirizzo viene
+// scritto dal kernel, quindi ha senso solo se la syscall è andata a buon fine.
+struct sockaddr_args {
+    __u8 addr_idx;
+    __u8 len_idx;
+    __u8 len_is_ptr;
+};
+
+// Tabella syscall_id -> argomenti che contengono un percorso
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, __u32);
+    __type(value, struct path_args);
+    __uint(max_entries, 64);
+} path_args_map SEC(".maps");
+
+// Tabella syscall_id -> argomenti con la sockaddr, anche questa riempita da Go
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, __u32);
+    __type(value, struct sockaddr_args);
+    __uint(max_entries, 16);
+} sockaddr_args_map SEC(".maps");
+
 // 2. LA DEFINIZIONE DEL RING BUFFER
 struct {
     __uint(type, BPF_MAP_TYPE_RINGBUF);
@@ -45,34 +332,623 @@ struct sys_enter_args {
     unsigned long args[6];
 };
 
+// Struttura fissa per raw_syscalls/sys_exit
+struct sys_exit_args {
+    __u16 common_type;
+    __u8  common_flags;
+    __u8  common_preempt_count;
+    __s32 common_pid;
+    long  id;  // ID della syscall
+    long  ret; // Valore di ritorno
+};
+
+// Copia dalla memoria utente i percorsi passati alla syscall (openat, stat, unlink, rename...)
+// Gli argomenti sono già in info->args; se la syscall non riceve percorsi le stringhe restano vuote
+static __always_inline void fill_paths(struct my_syscall_info *info) {
+    info->path[0][0] = 0;
+    info->path[1][0] = 0;
+
+    struct path_args *pa = bpf_map_lookup_elem(&path_args_map, &info->syscall_id);
+    if (!pa) {
+        return;
+    }
+
+    #pragma unroll
+    for (int i = 0; i < 2; i++) {
+        __u8 idx = pa->idx[i];
+        if (idx < 6) {
+            bpf_probe_read_user_str(info->path[i], PATH_LEN, (const void *)info->args[idx]);
+        }
+    }
+}
+
+// accept senza buffer per l'indirizzo (libuv chiama accept4(fd, NULL, NULL, flags)):
+// il peer si legge dal socket appena creato, fd restituito -> struct file -> struct socket -> sk,
+// e lo scriviamo come la sockaddr_in/sockaddr_in6 che il kernel avrebbe copiato
+static __always_inline void fill_peer_from_fd(struct my_syscall_info *info) {
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+    struct fdtable *fdt = BPF_CORE_READ(task, files, fdt);
+    if (!fdt || (__u64)info->ret >= BPF_CORE_READ(fdt, max_fds)) {
+        return;
+    }
+    struct file **fds = BPF_CORE_READ(fdt, fd);
+    struct file *file = NULL;
+    if (bpf_probe_read_kernel(&file, sizeof(file), &fds[info->ret]) || !file) {
+        return;
+    }
+    struct socket *sock = BPF_CORE_READ(file, private_data);
+    struct sock *sk = BPF_CORE_READ(sock, sk);
+    if (!sk) {
+        return;
+    }
+
+    __u16 family = BPF_CORE_READ(sk, __sk_common.skc_family);
+    __be16 port = BPF_CORE_READ(sk, __sk_common.skc_dport);
+    if (family == AF_INET) {
+        __be32 daddr = BPF_CORE_READ(sk, __sk_common.skc_daddr);
+        __builtin_memcpy(&info->addr[0], &family, 2);
+        __builtin_memcpy(&info->addr[2], &port, 2);
+        __builtin_memcpy(&info->addr[4], &daddr, 4);
+        info->addr_len = 16; // sizeof(struct sockaddr_in)
+    } else if (family == AF_INET6) {
+        __builtin_memset(&info->addr[4], 0, 4); // sin6_flowinfo
+        __builtin_memcpy(&info->addr[0], &family, 2);
+        __builtin_memcpy(&info->addr[2], &port, 2);
+        BPF_CORE_READ_INTO(&info->addr[8], sk, __sk_common.skc_v6_daddr);
+        __builtin_memset(&info->addr[24], 0, 4); // sin6_scope_id
+        info->addr_len = 28; // sizeof(struct sockaddr_in6)
+    }
+    // AF_UNIX: il client di solito non ha un nome, non c'è nulla da mostrare
+}
+
+// Copia la sockaddr passata (o restituita, per accept) dalla syscall
+static __always_inline void fill_sockaddr(struct my_syscall_info *info) {
+    info->addr_len = 0;
+
+    struct sockaddr_args *sa = bpf_map_lookup_elem(&sockaddr_args_map, &info->syscall_id);
+    if (!sa || sa->addr_idx >= 6 || sa->len_idx >= 6) {
+        return;
+    }
+    // accept fallita: il kernel non ha scritto nessun indirizzo
+    if (sa->len_is_ptr && info->ret < 0) {
+        return;
+    }
+
+    const void *addr = (const void *)info->args[sa->addr_idx];
+    if (!addr) {
+        // accept senza buffer: il peer si ricava dal socket restituito.
+        // Per le altre (es. sendto su un socket già connesso) non c'è indirizzo
+        if (sa->len_is_ptr) {
+            fill_peer_from_fd(info);
+        }
+        return;
+    }
+
+    __u32 len = 0;
+    if (sa->len_is_ptr) {
+        bpf_probe_read_user(&len, sizeof(len), (const void *)info->args[sa->len_idx]);
+    } else {
+        len = (__u32)info->args[sa->len_idx];
+    }
+    if (len > SOCKADDR_LEN) {
+        len = SOCKADDR_LEN;
+    }
+    if (len == 0) {
+        return;
+    }
+
+    if (bpf_probe_read_user(info->addr, len, addr) == 0) {
+        info->addr_len = len;
+    }
+}
+
+static __always_inline void count_stat(__u32 idx) {
+    __u64 *val = bpf_map_lookup_elem(&stats_map, &idx);
+    if (val) {
+        (*val)++;
+    }
+}
+
+// Aggiunge (delta = 1) o toglie (delta = -1) un riferimento allo stack
+static __always_inline void stack_ref(int stack_id, __s64 delta) {
+    if (stack_id < 0) {
+        return;
+    }
+    __u32 key = stack_id;
+    __u64 *refs = bpf_map_lookup_elem(&stack_refs, &key);
+    if (refs) {
+        __sync_fetch_and_add(refs, delta);
+    }
+}
+
+// Rilascia gli stack di un evento che non arriverà mai a Go
+static __always_inline void release_stacks(int stack_id, int kstack_id) {
+    stack_ref(stack_id, -1);
+    stack_ref(kstack_id, -1);
+}
+
+static __always_inline struct config *get_config(void) {
+    __u32 key = 0;
+    return bpf_map_lookup_elem(&config_map, &key);
+}
+
+static __always_inline bool is_target_pid(__u32 pid) {
+    return bpf_map_lookup_elem(&target_pid_map, &pid) != NULL;
+}
+
+// Il processo corrente è da monitorare se il suo PID è nella mappa, oppure se
+// il suo cgroup (o uno dei suoi antenati, es. l'intera slice) è tra quelli scelti
+static __always_inline bool is_target(__u32 pid) {
+    if (is_target_pid(pid)) {
+        return true;
+    }
+
+    struct config *cfg = get_config();
+    if (!cfg || !cfg->cgroup_filter) {
+        return false;
+    }
+
+    #pragma unroll
+    for (int level = 0; level < MAX_CGROUP_LEVELS; level++) {
+        __u64 cgroup_id = bpf_get_current_ancestor_cgroup_id(level);
+        if (!cgroup_id) {
+            break; // Superata la profondità del cgroup corrente
+        }
+        if (bpf_map_lookup_elem(&target_cgroup_map, &cgroup_id)) {
+            return true;
+        }
+    }
+    return false;
+}
+
+// Dice se la syscall va inviata in base al filtro scelto dall'utente
+static __always_inline bool syscall_wanted(struct config *cfg, long id) {
+    if (!cfg || cfg->syscall_filter == FILTER_NONE) {
+        return true;
+    }
+    if (id < 0 || id >= MAX_SYSCALL_ID) {
+        return cfg->syscall_filter == FILTER_DENY; // Fuori tabella: non può essere nella lista
+    }
+    __u32 key = (__u32)id;
+    __u8 *listed = bpf_map_lookup_elem(&syscall_filter_map, &key);
+    bool in_list = listed && *listed;
+    return cfg->syscall_filter == FILTER_ALLOW ? in_list : !in_list;
+}
+
+// Conta una syscall in agg_map. Due thread possono creare la stessa chiave insieme:
+// se l'inserimento fallisce perché l'altro ha vinto, sommiamo sulla sua entry
+static __always_inline void aggregate_syscall(__u64 pid_tgid, __u32 syscall_id, int stack_id, __u64 duration_ns) {
+    struct agg_key key = {
+        .pid = pid_tgid >> 32,
+        .tid = (__u32)pid_tgid,
+        .syscall_id = syscall_id,
+        .stack_id = stack_id,
+    };
+    struct agg_value *val = bpf_map_lookup_elem(&agg_map, &key);
+    if (!val) {
+        struct agg_value init = { .count = 1, .total_ns = duration_ns };
+        if (bpf_map_update_elem(&agg_map, &key, &init, BPF_NOEXIST) == 0) {
+            return;
+        }
+        val = bpf_map_lookup_elem(&agg_map, &key);
+        if (!val) {
+            count_stat(STAT_AGG_FULL); // Mappa piena: Go non l'ha ancora svuotata
+            stack_ref(stack_id, -1);   // La syscall non è contata da nessuna parte
+            return;
+        }
+    }
+    __sync_fetch_and_add(&val->count, 1);
+    __sync_fetch_and_add(&val->total_ns, duration_ns);
+}
+
+// Thread con un exec_event in attesa di esito, per TID (il valore è il PID)
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, __u32);
+    __type(value, __u32);
+    __uint(max_entries, 1024);
+} exec_pending SEC(".maps");
+
+// Invia l'esito dell'exec di un thread, se ne avevamo annunciato l'ingresso
+static __always_inline void emit_exec_result(__u32 tid, int ret) {
+    __u32 *pid = bpf_map_lookup_elem(&exec_pending, &tid);
+    if (!pid) {
+        return;
+    }
+    struct exec_result *r = bpf_ringbuf_reserve(&events, sizeof(*r), 0);
+    if (!r) {
+        count_stat(STAT_RINGBUF_OTHER);
+    } else {
+        r->type = EVENT_EXEC_RESULT;
+        r->pid = *pid;
+        r->tid = tid;
+        r->ret = ret;
+        r->timestamp_ns = bpf_ktime_get_ns();
+        bpf_ringbuf_submit(r, 0);
+    }
+    bpf_map_delete_elem(&exec_pending, &tid);
+}
+
+// Invia un exec_event con percorso, argv e envp letti dalla memoria utente
+static __always_inline void emit_exec(struct sys_enter_args *ctx, __u32 pid, __u32 tid, __u32 ppid) {
+    struct exec_event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0);
+    if (!e) {
+        count_stat(STAT_RINGBUF_OTHER);
+        return;
+    }
+
+    // execve(filename, argv, envp) - execveat(dirfd, filename, argv, envp, flags)
+    const char *filename;
+    const char *const *argv;
+    const char *const *envp;
+    if (ctx->id == SYS_EXECVEAT) {
+        e->dirfd = (int)ctx->args[0];
+        filename = (const char *)ctx->args[1];
+        argv = (const char *const *)ctx->args[2];
+        envp = (const char *const *)ctx->args[3];
+    } else {
+        e->dirfd = -100; // AT_FDCWD
+        filename = (const char *)ctx->args[0];
+        argv = (const char *const *)ctx->args[1];
+        envp = (const char *const *)ctx->args[2];
+    }
+
+    e->type = EVENT_EXEC;
+    e->pid = pid;
+    e->ppid = ppid;
+    e->timestamp_ns = bpf_ktime_get_ns();
+    e->stack_id = bpf_get_stackid(ctx, &stack_map, BPF_F_USER_STACK);
+    if (e->stack_id < 0) {
+        count_stat(STAT_STACK_USER);
+    }
+    stack_ref(e->stack_id, 1);
+    e->argc = 0;
+    e->envc = 0;
+    e->tid = tid;
+    e->filename[0] = 0;
+    bpf_probe_read_user_str(e->filename, sizeof(e->filename), filename);
+
+    // argv ed envp sono array di puntatori terminati da NULL
+    #pragma unroll
+    for (int i = 0; i < EXEC_MAX_ARGS; i++) {
+        const char *arg = NULL;
+        if (bpf_probe_read_user(&arg, sizeof(arg), &argv[i]) || !arg) {
+            break;
+        }
+        bpf_probe_read_user_str(e->argv[i], EXEC_ARG_LEN, arg);
+        e->argc++;
+    }
+    #pragma unroll
+    for (int i = 0; i < EXEC_MAX_ENV; i++) {
+        const char *env = NULL;
+        if (!envp || bpf_probe_read_user(&env, sizeof(env), &envp[i]) || !env) {
+            break;
+        }
+        bpf_probe_read_user_str(e->envp[i], EXEC_ENV_LEN, env);
+        e->envc++;
+    }
+
+    bpf_ringbuf_submit(e, 0);
+    bpf_map_update_elem(&exec_pending, &tid, &pid, BPF_ANY);
+}
+
+// Riempie l'evento di exit/exit_group, che parte direttamente dal sys_enter
+static __always_inline void fill_noreturn_info(struct my_syscall_info *info, struct sys_enter_args *ctx,
+                                               __u64 pid_tgid, int stack_id, int kstack_id) {
+    info->type = EVENT_SYSCALL;
+    info->pid = pid_tgid >> 32;
+    info->tid = (__u32)pid_tgid;
+    bpf_get_current_comm(info->comm, sizeof(info->comm));
+    info->timestamp_ns = bpf_ktime_get_ns();
+    info->duration_ns = 0;
+    info->ret = 0;
+    #pragma unroll
+    for (int i = 0; i < 6; i++) {
+        info->args[i] = ctx->args[i];
+    }
+    info->syscall_id = (__u32)ctx->id;
+    info->stack_id = stack_id;
+    info->kstack_id = kstack_id;
+    info->kstack_blocked = 0;
+    fill_paths(info);
+    fill_sockaddr(info);
+}
+
+// Riempie l'evento completo al sys_exit, a partire dall'ingresso salvato
+static __always_inline void fill_exit_info(struct my_syscall_info *info, struct enter_info *enter,
+                                           __u64 pid_tgid, long ret) {
+    info->type = EVENT_SYSCALL;
+    info->pid = pid_tgid >> 32;
+    info->tid = (__u32)pid_tgid;
+    bpf_get_current_comm(info->comm, sizeof(info->comm));
+    info->timestamp_ns = enter->timestamp_ns;
+    info->duration_ns = bpf_ktime_get_ns() - enter->timestamp_ns;
+    info->ret = ret;
+    #pragma unroll
+    for (int i = 0; i < 6; i++) {
+        info->args[i] = enter->args[i];
+    }
+    info->syscall_id = enter->syscall_id;
+    info->stack_id = enter->stack_id;
+    info->kstack_id = enter->kstack_id;
+    info->kstack_blocked = enter->kstack_blocked;
+
+    // Leggiamo i percorsi qui e non al sys_enter: finché il thread non torna in
+    // user space la sua memoria non cambia, e così non appesantiamo enter_map.
+    // Fa eccezione l'exec riuscito, che sostituisce l'intero spazio di indirizzi: execve ed
+    // execveat non sono in path_args_map, il binario arriva con exec_event dal sys_enter
+    fill_paths(info);
+    // Stesso discorso per la sockaddr; per accept inoltre l'indirizzo del peer esiste solo all'uscita
+    fill_sockaddr(info);
+}
+
+static __always_inline struct inline_event *get_inline_scratch(void) {
+    __u32 key = 0;
+    return bpf_map_lookup_elem(&inline_scratch, &key);
+}
+
+// Completa una inline_event con lo stack utente e la invia copiando solo i frame validi.
+// Lo stack utente non cambia tra sys_enter e sys_exit (i registri utente sono salvati
+// all'ingresso nel kernel), quindi catturarlo all'uscita dà lo stesso risultato.
+// Resta lo stack del kernel, che passa ancora da stack_map: se l'invio fallisce lo rilasciamo
+static __always_inline void output_inline(void *ctx, struct inline_event *ev) {
+    ev->info.type = EVENT_SYSCALL_INLINE;
+    long len = bpf_get_stack(ctx, ev->frames, sizeof(ev->frames), BPF_F_USER_STACK);
+    if (len < 0) {
+        count_stat(STAT_STACK_USER);
+        len = 0;
+    }
+    if (len > sizeof(ev->frames)) {
+        len = sizeof(ev->frames); // Limite esplicito per il verifier
+    }
+    ev->nr_frames = len / sizeof(__u64);
+
+    __u64 size = offsetof(struct inline_event, frames) + len;
+    if (bpf_ringbuf_output(&events, ev, size, 0)) {
+        count_stat(STAT_RINGBUF_FULL);
+        stack_ref(ev->info.kstack_id, -1);
+    }
+}
+
+// ---------------------------------------------------------------------------
+// NUOVO CODICE MAPPATO (dlopen di librerie e addon .node)
+// Il Symbolizer legge /proc/<PID>/maps all'avvio: una libreria caricata dopo resterebbe
+// sconosciuta. Quando un target mappa codice da un file (mmap con PROT_EXEC, come fa il
+// loader per il segmento di testo) o rende eseguibile della memoria (mprotect), avvisiamo
+// Go. La syscall viene riconosciuta al sys_enter, dove ci sono gli argomenti, ma l'avviso
+// parte al sys_exit e solo se è riuscita: prima la mappatura non esiste ancora in
+// /proc/<PID>/maps. Al massimo un avviso per processo ogni MAPS_EVENT_INTERVAL_NS: V8 può
+// chiamare mprotect molto spesso sul proprio codice, e a Go basta sapere che qualcosa è cambiato
+// ---------------------------------------------------------------------------
+
+#define MAPS_EVENT_INTERVAL_NS 100000000ULL // 100ms
+
+struct maps_event {
+    __u32 type; // EVENT_MAPS
+    __u32 pid;
+    __u64 timestamp_ns;
+};
+
+// Ultimo avviso inviato per processo
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, __u32);
+    __type(value, __u64);
+    __uint(max_entries, 1024);
+} maps_last_event SEC(".maps");
+
+// Thread dentro una mmap/mprotect di codice, per TID (il valore non conta)
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, __u64);
+    __type(value, __u8);
+    __uint(max_entries, 1024);
+} maps_pending SEC(".maps");
+
+static __always_inline void check_new_code(struct sys_enter_args *ctx, __u64 pid_tgid) {
+    if (ctx->id == SYS_MMAP) {
+        // Solo mappature di file: la memoria anonima eseguibile è il codice JIT, che sta nella perf-map
+        if (!(ctx->args[2] & PROT_EXEC) || (ctx->args[3] & MAP_ANONYMOUS)) {
+            return;
+        }
+    } else if (ctx->id == SYS_MPROTECT) {
+        if (!(ctx->args[2] & PROT_EXEC)) {
+            return;
+        }
+    } else {
+        return;
+    }
+    __u8 one = 1;
+    bpf_map_update_elem(&maps_pending, &pid_tgid, &one, BPF_ANY);
+}
+
+static __always_inline void emit_new_code(struct sys_exit_args *ctx, __u64 pid_tgid) {
+    if (ctx->id != SYS_MMAP && ctx->id != SYS_MPROTECT) {
+        return;
+    }
+    if (!bpf_map_lookup_elem(&maps_pending, &pid_tgid)) {
+        return;
+    }
+    bpf_map_delete_elem(&maps_pending, &pid_tgid);
+    // Errore: nessuna nuova mappatura (gli errori sono tra -4095 e -1)
+    if (ctx->ret < 0 && ctx->ret >= -4095) {
+        return;
+    }
+
+    __u32 pid = pid_tgid >> 32;
+    __u64 now = bpf_ktime_get_ns();
+    __u64 *last = bpf_map_lookup_elem(&maps_last_event, &pid);
+    if (last && now - *last < MAPS_EVENT_INTERVAL_NS) {
+        return;
+    }
+    bpf_map_update_elem(&maps_last_event, &pid, &now, BPF_ANY);
+
+    struct maps_event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0);
+    if (!e) {
+        count_stat(STAT_RINGBUF_OTHER);
+        return;
+    }
+    e->type = EVENT_MAPS;
+    e->pid = pid;
+    e->timestamp_ns = now;
+    bpf_ringbuf_submit(e, 0);
+}
+
 SEC("tracepoint/raw_syscalls/sys_enter")
 int trace_sys_enter(struct sys_enter_args *ctx) {
     __u64 pid_tgid = bpf_get_current_pid_tgid();
     __u32 pid = pid_tgid >> 32;
+    bool target = is_target(pid);
+
+    // Gli exec vanno controllati prima del filtro: child_process.spawn fa fork e poi
+    // execve nel figlio, che non è tra i PID monitorati ma ha come padre il processo Node.
+    if (ctx->id == SYS_EXECVE || ctx->id == SYS_EXECVEAT) {
+        struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+        __u32 ppid = BPF_CORE_READ(task, real_parent, tgid);
+        if (target || is_target_pid(ppid)) {
+            emit_exec(ctx, pid, (__u32)pid_tgid, ppid);
+        }
+    }
 
-    __u32 array_key = 0;
-    __u32 *target_pid = bpf_map_lookup_elem(&target_pid_map, &array_key);
-    if (!target_pid || *target_pid != pid) {
+    if (!target) {
         return 0;
     }
 
-    // Cerchiamo lo stack (se fallisce, usciamo per non inviare dati inutili)
-    int stack_id = bpf_get_stackid(ctx, &stack_map, BPF_F_USER_STACK);
-    if (stack_id < 0) {
-        return 0; 
+    // Anche questo prima del filtro: senza le nuove librerie gli stack non si risolvono
+    check_new_code(ctx, pid_tgid);
+
+    // Filtro sulle syscall: le scartiamo qui, prima di pagare lo stack e il ring buffer.
+    // Senza entry in enter_map anche il sys_exit corrispondente viene ignorato
+    struct config *cfg = get_config();
+    if (!syscall_wanted(cfg, ctx->id)) {
+        return 0;
+    }
+    count_stat(STAT_SYSCALLS);
+
+    // Cerchiamo lo stack. Se fallisce inviamo comunque l'evento con lo stack_id negativo
+    // (-errno): la syscall è avvenuta, e Go deve poterla contare.
+    // Con --inline-stacks lo stack viene copiato nel record al sys_exit, stack_map non serve
+    bool inline_stacks = cfg && cfg->inline_stacks;
+    int stack_id = -1;
+    if (!inline_stacks) {
+        stack_id = bpf_get_stackid(ctx, &stack_map, BPF_F_USER_STACK);
+        if (stack_id < 0) {
+            count_stat(STAT_STACK_USER);
+        }
+        stack_ref(stack_id, 1);
+    }
+
+    // Stack del kernel, se richiesto. Qui contiene solo il percorso di ingresso della syscall:
+    // se il thread si blocca, trace_sched_switch lo sostituisce con lo stack del punto di attesa
+    int kstack_id = -1;
+    if (cfg && cfg->kernel_stacks) {
+        kstack_id = bpf_get_stackid(ctx, &stack_map, 0);
+        if (kstack_id < 0) {
+            count_stat(STAT_STACK_KERNEL);
+        }
+        stack_ref(kstack_id, 1);
+    }
+
+    // exit ed exit_group non ritornano: inviamo subito l'evento senza ret e durata
+    if (ctx->id == SYS_EXIT || ctx->id == SYS_EXIT_GROUP) {
+        if (cfg && cfg->aggregate) {
+            aggregate_syscall(pid_tgid, (__u32)ctx->id, stack_id, 0);
+            return 0;
+        }
+        if (inline_stacks) {
+            struct inline_event *ev = get_inline_scratch();
+            if (!ev) {
+                release_stacks(stack_id, kstack_id);
+                return 0;
+            }
+            fill_noreturn_info(&ev->info, ctx, pid_tgid, stack_id, kstack_id);
+            output_inline(ctx, ev);
+            return 0;
+        }
+        struct my_syscall_info *info = bpf_ringbuf_reserve(&events, sizeof(*info), 0);
+        if (!info) {
+            count_stat(STAT_RINGBUF_FULL);
+            release_stacks(stack_id, kstack_id);
+            return 0;
+        }
+        fill_noreturn_info(info, ctx, pid_tgid, stack_id, kstack_id);
+        bpf_ringbuf_submit(info, 0);
+        return 0;
+    }
+
+    // Salviamo l'ingresso: l'evento verrà inviato da trace_sys_exit
+    struct enter_info enter = {
+        .timestamp_ns = bpf_ktime_get_ns(),
+        .syscall_id = (__u32)ctx->id,
+        .stack_id = stack_id,
+        .kstack_id = kstack_id,
+        .kstack_blocked = 0,
+    };
+    #pragma unroll
+    for (int i = 0; i < 6; i++) {
+        enter.args[i] = ctx->args[i];
+    }
+    if (bpf_map_update_elem(&enter_map, &pid_tgid, &enter, BPF_ANY)) {
+        count_stat(STAT_ENTER_FULL);
+        release_stacks(stack_id, kstack_id);
+    }
+
+    return 0;
+}
+
+SEC("tracepoint/raw_syscalls/sys_exit")
+int trace_sys_exit(struct sys_exit_args *ctx) {
+    __u64 pid_tgid = bpf_get_current_pid_tgid();
+
+    // Prima del filtro, come al sys_enter
+    emit_new_code(ctx, pid_tgid);
+    // Un exec riuscito non torna qui con il vecchio programma: lo ha già confermato sched_process_exec
+    if ((ctx->id == SYS_EXECVE || ctx->id == SYS_EXECVEAT) && ctx->ret < 0) {
+        emit_exec_result((__u32)pid_tgid, (int)ctx->ret);
+    }
+
+    // Se non c'è un ingresso salvato per questo thread, la syscall non ci interessa
+    // (PID diverso, oppure syscall esclusa dal filtro)
+    struct enter_info *enter = bpf_map_lookup_elem(&enter_map, &pid_tgid);
+    if (!enter) {
+        return 0;
+    }
+
+    // In modalità aggregata niente ring buffer: basta un contatore per (thread, syscall, stack)
+    struct config *cfg = get_config();
+    if (cfg && cfg->aggregate) {
+        aggregate_syscall(pid_tgid, enter->syscall_id, enter->stack_id, bpf_ktime_get_ns() - enter->timestamp_ns);
+        bpf_map_delete_elem(&enter_map, &pid_tgid);
+        return 0;
+    }
+
+    // Con --inline-stacks il record ha lunghezza variabile: lo costruiamo nell'area
+    // di appoggio per CPU e lo copiamo nel ring buffer con bpf_ringbuf_output
+    if (cfg && cfg->inline_stacks) {
+        struct inline_event *ev = get_inline_scratch();
+        if (ev) {
+            fill_exit_info(&ev->info, enter, pid_tgid, ctx->ret);
+            output_inline(ctx, ev);
+        } else {
+            release_stacks(enter->stack_id, enter->kstack_id);
+        }
+        bpf_map_delete_elem(&enter_map, &pid_tgid);
+        return 0;
     }
 
     // 3. PRENOTIAMO LO SPAZIO NEL RING BUFFER
-    // Chiediamo al kernel un blocco di 16 byte. Se il buffer è pieno, restituisce NULL.
+    // Chiediamo al kernel un blocco di 760 byte. Se il buffer è pieno, restituisce NULL.
     struct my_syscall_info *info = bpf_ringbuf_reserve(&events, sizeof(*info), 0);
     if (!info) {
+        release_stacks(enter->stack_id, enter->kstack_id);
+        bpf_map_delete_elem(&enter_map, &pid_tgid);
+        count_stat(STAT_RINGBUF_FULL);
         return 0; // Buffer temporaneamente pieno, evento scartato
     }
 
     // 4. POPOLIAMO I DATI
-    info->timestamp_ns = bpf_ktime_get_ns();
-    info->syscall_id = (__u32)ctx->id;
-    info->stack_id = stack_id;
+    fill_exit_info(info, enter, pid_tgid, ctx->ret);
+    bpf_map_delete_elem(&enter_map, &pid_tgid);
 
     // 5. INVIAMO L'EVENTO ALLO USER SPACE
     // Da questo momento, il programma Go viene "svegliato"
@@ -81,4 +957,786 @@ int trace_sys_enter(struct sys_enter_args *ctx) {
     return 0;
 }
 
+// Cambio di contesto: se il thread che lascia la CPU è dentro una syscall monitorata,
+// salviamo lo stack del kernel nel punto in cui si blocca (es. write -> ext4 -> jbd2).
+// È lo stack che dice cosa ha fatto il kernel durante la syscall. Agganciato solo con --kstack.
+SEC("tp_btf/sched_switch")
+int BPF_PROG(trace_sched_switch, bool preempt, struct task_struct *prev, struct task_struct *next) {
+    // Una preemption non è un'attesa: il thread tornerà in CPU appena possibile
+    if (preempt) {
+        return 0;
+    }
+    // In sched_switch il processo corrente è ancora prev
+    __u64 pid_tgid = bpf_get_current_pid_tgid();
+    struct enter_info *enter = bpf_map_lookup_elem(&enter_map, &pid_tgid);
+    if (!enter || enter->kstack_blocked) {
+        return 0; // Non è in una syscall monitorata, oppure abbiamo già il primo punto di attesa
+    }
+
+    int kstack_id = bpf_get_stackid(ctx, &stack_map, 0);
+    if (kstack_id < 0) {
+        count_stat(STAT_STACK_KERNEL);
+        return 0; // Teniamo lo stack di ingresso
+    }
+    // Lo stack di ingresso non verrà più inviato: passiamo il riferimento al nuovo
+    stack_ref(kstack_id, 1);
+    stack_ref(enter->kstack_id, -1);
+    enter->kstack_id = kstack_id;
+    enter->kstack_blocked = 1;
+    return 0;
+}
+
+// Invia un proc_event riempiendo i campi comuni
+static __always_inline struct proc_event *reserve_proc_event(__u32 kind, __u32 pid, __u32 ppid) {
+    struct proc_event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0);
+    if (!e) {
+        count_stat(STAT_RINGBUF_OTHER);
+        return NULL;
+    }
+    e->type = EVENT_PROC;
+    e->kind = kind;
+    e->pid = pid;
+    e->ppid = ppid;
+    e->timestamp_ns = bpf_ktime_get_ns();
+    e->exit_code = 0;
+    e->_pad = 0;
+    e->filename[0] = 0;
+    bpf_get_current_comm(e->comm, sizeof(e->comm));
+    return e;
+}
+
+// fork/clone: se il padre è monitorato, aggiungiamo il figlio ai target.
+// Lo facciamo nel kernel così nessuna syscall del figlio va persa in attesa di Go.
+SEC("tp_btf/sched_process_fork")
+int BPF_PROG(trace_sched_fork, struct task_struct *parent, struct task_struct *child) {
+    __u32 ppid = BPF_CORE_READ(parent, tgid);
+    __u32 pid = BPF_CORE_READ(child, tgid);
+
+    // Anche i thread nascono da una clone: li riconosciamo perché condividono il tgid del padre
+    if (pid == ppid || BPF_CORE_READ(child, pid) != pid) {
+        return 0;
+    }
+    // Qui il processo corrente è il padre, quindi is_target controlla anche il suo cgroup
+    if (!is_target(ppid)) {
+        return 0;
+    }
+
+    bpf_map_update_elem(&target_pid_map, &pid, &ppid, BPF_ANY);
+
+    struct proc_event *e = reserve_proc_event(PROC_FORK, pid, ppid);
+    if (e) {
+        bpf_ringbuf_submit(e, 0);
+    }
+    return 0;
+}
+
+// exec riuscito: Go deve ricaricare le mappe di memoria del processo, che ora esegue un altro binario
+SEC("tp_btf/sched_process_exec")
+int BPF_PROG(trace_sched_exec, struct task_struct *p, pid_t old_pid, struct linux_binprm *bprm) {
+    // old_pid è il TID che ha chiamato execve: se non era il thread principale,
+    // dopo l'exec ha preso il PID del processo
+    emit_exec_result(old_pid, 0);
+
+    __u32 pid = BPF_CORE_READ(p, tgid);
+    if (!is_target_pid(pid)) {
+        return 0;
+    }
+
+    struct proc_event *e = reserve_proc_event(PROC_EXEC, pid, 0);
+    if (!e) {
+        return 0;
+    }
+    bpf_probe_read_kernel_str(e->filename, sizeof(e->filename), BPF_CORE_READ(bprm, filename));
+    bpf_ringbuf_submit(e, 0);
+    return 0;
+}
+
+// Uscita di un processo monitorato: lo togliamo dai target e avvisiamo Go per liberare il suo stato
+SEC("tp_btf/sched_process_exit")
+int BPF_PROG(trace_sched_exit, struct task_struct *p) {
+    __u32 pid = BPF_CORE_READ(p, tgid);
+
+    // L'evento scatta per ogni thread: ci interessa solo il thread principale.
+    // Il processo corrente è quello che esce, quindi vale anche il filtro per cgroup
+    if (BPF_CORE_READ(p, pid) != pid || !is_target(pid)) {
+        return 0;
+    }
+
+    bpf_map_delete_elem(&target_pid_map, &pid);
+
+    struct proc_event *e = reserve_proc_event(PROC_EXIT, pid, 0);
+    if (!e) {
+        return 0;
+    }
+    e->exit_code = BPF_CORE_READ(p, exit_code);
+    bpf_ringbuf_submit(e, 0);
+    return 0;
+}
+
+// ---------------------------------------------------------------------------
+// SEGNALI: chi uccide il processo e dove si trovava
+// Seguiamo solo i segnali che di default terminano il processo (SIGSEGV di un addon,
+// SIGABRT di un errore fatale di V8, SIGKILL dell'OOM killer, SIGTERM...). Alla generazione
+// registriamo il mittente; alla consegna, che avviene nel thread destinatario con i suoi
+// registri utente, catturiamo lo stack: per un SIGSEGV è il punto esatto del crash
+// ---------------------------------------------------------------------------
+
+#define SI_USER   0
+#define SI_KERNEL 0x80
+
+#define SIG_BIT(sig) (1UL << (sig))
+#define FATAL_SIGNALS (SIG_BIT(1) /* SIGHUP */ | SIG_BIT(2) /* SIGINT */ | SIG_BIT(3) /* SIGQUIT */ | \
+                       SIG_BIT(4) /* SIGILL */ | SIG_BIT(5) /* SIGTRAP */ | SIG_BIT(6) /* SIGABRT */ | \
+                       SIG_BIT(7) /* SIGBUS */ | SIG_BIT(8) /* SIGFPE */ | SIG_BIT(9) /* SIGKILL */ | \
+                       SIG_BIT(11) /* SIGSEGV */ | SIG_BIT(15) /* SIGTERM */ | SIG_BIT(31) /* SIGSYS */)
+
+struct signal_event {
+    __u32 type;       // EVENT_SIGNAL
+    __u32 kind;       // SIGNAL_GENERATE / SIGNAL_DELIVER
+    __u32 pid;        // Processo e thread destinatari
+    __u32 tid;
+    __u32 sender_pid; // Per SIGNAL_GENERATE: il processo corrente, che ha inviato il segnale
+    __u32 sender_tid;
+    int   sig;
+    int   code;       // si_code: <= 0 inviato da un processo (kill, tgkill...), > 0 dal kernel
+    __u64 timestamp_ns;
+    __u64 handler;    // Per SIGNAL_DELIVER: sa_handler (0 = SIG_DFL, 1 = SIG_IGN)
+    char  comm[16];   // Mittente per SIGNAL_GENERATE, destinatario per SIGNAL_DELIVER
+    __u32 nr_frames;  // Per SIGNAL_DELIVER: frame validi in frames
+    __u32 _pad;
+    __u64 frames[MAX_STACK_DEPTH];
+};
+
+static __always_inline bool is_fatal_signal(int sig) {
+    return sig > 0 && sig < 64 && (FATAL_SIGNALS & SIG_BIT(sig));
+}
+
+// info può essere uno dei valori speciali SEND_SIG_NOINFO (0) o SEND_SIG_PRIV (1)
+static __always_inline int signal_code(struct kernel_siginfo *info) {
+    if ((unsigned long)info <= 1) {
+        return info ? SI_KERNEL : SI_USER;
+    }
+    return BPF_CORE_READ(info, si_code);
+}
+
+static __always_inline struct signal_event *reserve_signal_event(__u32 kind, int sig, struct kernel_siginfo *info) {
+    struct signal_event *e = bpf_ringbuf_reserve(&events, sizeof(*e), 0);
+    if (!e) {
+        count_stat(STAT_RINGBUF_OTHER);
+        return NULL;
+    }
+    e->type = EVENT_SIGNAL;
+    e->kind = kind;
+    e->sig = sig;
+    e->code = signal_code(info);
+    e->timestamp_ns = bpf_ktime_get_ns();
+    e->handler = 0;
+    e->nr_frames = 0;
+    e->_pad = 0;
+    bpf_get_current_comm(e->comm, sizeof(e->comm));
+    return e;
+}
+
+// Il processo corrente è il mittente, quindi il filtro per cgroup non si applica al destinatario:
+// guardiamo solo target_pid_map, che contiene anche i processi visti nei cgroup scelti
+SEC("tp_btf/signal_generate")
+int BPF_PROG(trace_signal_generate, int sig, struct kernel_siginfo *info, struct task_struct *task, int group, int result) {
+    __u32 pid = BPF_CORE_READ(task, tgid);
+    // result != 0: segnale ignorato o già in attesa, non arriverà (di nuovo) al processo
+    if (result != 0 || !is_fatal_signal(sig) || !is_target_pid(pid)) {
+        return 0;
+    }
+
+    struct signal_event *e = reserve_signal_event(SIGNAL_GENERATE, sig, info);
+    if (!e) {
+        return 0;
+    }
+    __u64 pid_tgid = bpf_get_current_pid_tgid();
+    e->pid = pid;
+    e->tid = BPF_CORE_READ(task, pid);
+    e->sender_pid = pid_tgid >> 32;
+    e->sender_tid = (__u32)pid_tgid;
+    bpf_ringbuf_submit(e, 0);
+    return 0;
+}
+
+SEC("tp_btf/signal_deliver")
+int BPF_PROG(trace_signal_deliver, int sig, struct kernel_siginfo *info, struct k_sigaction *ka) {
+    __u64 pid_tgid = bpf_get_current_pid_tgid();
+    __u32 pid = pid_tgid >> 32;
+    if (!is_fatal_signal(sig) || !is_target(pid)) {
+        return 0;
+    }
+
+    struct signal_event *e = reserve_signal_event(SIGNAL_DELIVER, sig, info);
+    if (!e) {
+        return 0;
+    }
+    e->pid = pid;
+    e->tid = (__u32)pid_tgid;
+    e->sender_pid = 0;
+    e->sender_tid = 0;
+    e->handler = (__u64)BPF_CORE_READ(ka, sa.sa_handler);
+
+    long size = bpf_get_stack(ctx, e->frames, sizeof(e->frames), BPF_F_USER_STACK);
+    if (size < 0) {
+        count_stat(STAT_STACK_USER);
+        size = 0;
+    }
+    e->nr_frames = size / sizeof(__u64);
+    bpf_ringbuf_submit(e, 0);
+    return 0;
+}
+
+// ---------------------------------------------------------------------------
+// UPROBE DEFINITE DALL'UTENTE (--uprobe / --uretprobe binario:simbolo)
+// Go aggancia uprobe_entry (e con --uretprobe anche uprobe_return) alle funzioni scelte,
+// es. uv_fs_open nel binario node o SSL_write in libssl. Il cookie dell'aggancio contiene
+// l'id della sonda e, nel bit UPROBE_WITH_RETURN, se aspettare il ritorno per inviare
+// l'evento. Gli eventi sono my_syscall_info con EVENT_UPROBE: stesso percorso in Go,
+// stessa stack_map con conteggio dei riferimenti
+// ---------------------------------------------------------------------------
+
+#define UPROBE_WITH_RETURN (1ULL << 32)
+
+// Ingressi in attesa del ritorno, per thread e sonda: due funzioni annidate (es. uv_fs_open
+// che chiama open di libc) hanno ognuna la propria entry. Una funzione ricorsiva sovrascrive
+// invece il proprio ingresso: vediamo solo l'ultimo livello, e lo contiamo in STAT_UPROBE_LOST.
+// LRU: i thread che terminano o escono dalla funzione con longjmp non passano dal ritorno
+struct uprobe_key {
+    __u64 pid_tgid;
+    __u32 probe_id;
+    __u32 pad;
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, struct uprobe_key);
+    __type(value, struct enter_info);
+    __uint(max_entries, 10240);
+} uprobe_enter_map SEC(".maps");
+
+static __always_inline void emit_uprobe(struct enter_info *enter, __u64 pid_tgid, long ret, __u64 duration_ns) {
+    struct my_syscall_info *info = bpf_ringbuf_reserve(&events, sizeof(*info), 0);
+    if (!info) {
+        count_stat(STAT_RINGBUF_FULL);
+        stack_ref(enter->stack_id, -1);
+        return;
+    }
+    info->type = EVENT_UPROBE;
+    info->syscall_id = enter->syscall_id;
+    info->pid = pid_tgid >> 32;
+    info->tid = (__u32)pid_tgid;
+    bpf_get_current_comm(info->comm, sizeof(info->comm));
+    info->timestamp_ns = enter->timestamp_ns;
+    info->duration_ns = duration_ns;
+    info->ret = ret;
+    #pragma unroll
+    for (int i = 0; i < 6; i++) {
+        info->args[i] = enter->args[i];
+    }
+    info->stack_id = enter->stack_id;
+    info->kstack_id = -1;
+    info->kstack_blocked = 0;
+    info->addr_len = 0;
+    info->path[0][0] = 0;
+    info->path[1][0] = 0;
+    bpf_ringbuf_submit(info, 0);
+}
+
+SEC("uprobe")
+int uprobe_entry(struct pt_regs *ctx) {
+    __u64 pid_tgid = bpf_get_current_pid_tgid();
+    if (!is_target(pid_tgid >> 32)) {
+        return 0;
+    }
+    __u64 cookie = bpf_get_attach_cookie(ctx);
+
+    int stack_id = bpf_get_stackid(ctx, &stack_map, BPF_F_USER_STACK);
+    if (stack_id < 0) {
+        count_stat(STAT_STACK_USER);
+    }
+    stack_ref(stack_id, 1);
+
+    struct enter_info enter = {
+        .timestamp_ns = bpf_ktime_get_ns(),
+        .args = {
+            PT_REGS_PARM1(ctx), PT_REGS_PARM2(ctx), PT_REGS_PARM3(ctx),
+            PT_REGS_PARM4(ctx), PT_REGS_PARM5(ctx), PT_REGS_PARM6(ctx),
+        },
+        .syscall_id = (__u32)cookie,
+        .stack_id = stack_id,
+        .kstack_id = -1,
+        .kstack_blocked = 0,
+    };
+
+    // Solo ingresso: l'evento parte subito, senza ritorno e durata
+    if (!(cookie & UPROBE_WITH_RETURN)) {
+        emit_uprobe(&enter, pid_tgid, 0, 0);
+        return 0;
+    }
+    struct uprobe_key key = { .pid_tgid = pid_tgid, .probe_id = (__u32)cookie };
+    struct enter_info *old = bpf_map_lookup_elem(&uprobe_enter_map, &key);
+    if (old) {
+        // Ingresso ricorsivo: il livello precedente non verrà inviato
+        count_stat(STAT_UPROBE_LOST);
+        stack_ref(old->stack_id, -1);
+    }
+    if (bpf_map_update_elem(&uprobe_enter_map, &key, &enter, BPF_ANY)) {
+        count_stat(STAT_ENTER_FULL);
+        stack_ref(stack_id, -1);
+    }
+    return 0;
+}
+
+SEC("uretprobe")
+int uprobe_return(struct pt_regs *ctx) {
+    __u64 pid_tgid = bpf_get_current_pid_tgid();
+    if (!is_target(pid_tgid >> 32)) {
+        return 0;
+    }
+    struct uprobe_key key = { .pid_tgid = pid_tgid, .probe_id = (__u32)bpf_get_attach_cookie(ctx) };
+    struct enter_info *enter = bpf_map_lookup_elem(&uprobe_enter_map, &key);
+    if (!enter) {
+        // Ritorno di un livello ricorsivo già sovrascritto, o ingresso mai salvato
+        count_stat(STAT_UPROBE_LOST);
+        return 0;
+    }
+    emit_uprobe(enter, pid_tgid, PT_REGS_RC(ctx), bpf_ktime_get_ns() - enter->timestamp_ns);
+    bpf_map_delete_elem(&uprobe_enter_map, &key);
+    return 0;
+}
+
+// ---------------------------------------------------------------------------
+// MODALITÀ PROFILE: campionamento della CPU
+// Go apre un evento perf software (CPU clock) su ogni CPU e ci aggancia profile_cpu:
+// a ogni campione, se sulla CPU gira un thread monitorato, contiamo il suo stack.
+// Così si vede anche il codice che non fa syscall (es. un calcolo JS in un ciclo stretto)
+// ---------------------------------------------------------------------------
+
+struct profile_key {
+    __u32 pid;
+    __u32 tid;
+    int   stack_id;  // Stack utente (-errno se non catturato)
+    int   kstack_id; // Stack del kernel, se il campione è caduto dentro il kernel
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, struct profile_key);
+    __type(value, __u64);
+    __uint(max_entries, 16384);
+} profile_counts SEC(".maps");
+
+// Stack delle modalità di profiling (profile, offcpu, futex) e delle page fault: una mappa separata, più grande, che non compete con quella delle syscall
+struct {
+    __uint(type, BPF_MAP_TYPE_STACK_TRACE);
+    __uint(key_size, sizeof(__u32));
+    __uint(value_size, MAX_STACK_DEPTH * sizeof(__u64));
+    __uint(max_entries, 16384);
+} profile_stacks SEC(".maps");
+
+SEC("perf_event")
+int profile_cpu(struct bpf_perf_event_data *ctx) {
+    __u64 pid_tgid = bpf_get_current_pid_tgid();
+    __u32 pid = pid_tgid >> 32;
+    if (pid == 0 || !is_target(pid)) {
+        return 0; // CPU inattiva o processo non monitorato
+    }
+
+    struct profile_key key = {
+        .pid = pid,
+        .tid = (__u32)pid_tgid,
+        .stack_id = bpf_get_stackid(ctx, &profile_stacks, BPF_F_USER_STACK),
+        .kstack_id = bpf_get_stackid(ctx, &profile_stacks, 0),
+    };
+    if (key.stack_id < 0) {
+        count_stat(STAT_STACK_USER);
+    }
+
+    __u64 *count = bpf_map_lookup_elem(&profile_counts, &key);
+    if (count) {
+        __sync_fetch_and_add(count, 1);
+        return 0;
+    }
+    __u64 one = 1;
+    if (bpf_map_update_elem(&profile_counts, &key, &one, BPF_NOEXIST)) {
+        count = bpf_map_lookup_elem(&profile_counts, &key);
+        if (count) {
+            __sync_fetch_and_add(count, 1);
+        } else {
+            count_stat(STAT_AGG_FULL);
+        }
+    }
+    return 0;
+}
+
+// ---------------------------------------------------------------------------
+// MODALITÀ OFFCPU: dove stanno fermi i thread
+// Quando un thread monitorato lascia la CPU salviamo i suoi stack (utente e kernel) e
+// l'istante; sched_wakeup segna quando diventa di nuovo eseguibile, e quando torna in CPU
+// sommiamo il tempo bloccato (fino al risveglio) e quello in runqueue (dal risveglio
+// all'esecuzione) sulla combinazione (thread, stack)
+// ---------------------------------------------------------------------------
+
+struct offcpu_start {
+    __u64 off_ns;    // Uscita dalla CPU
+    __u64 wakeup_ns; // Risveglio (0 finché il thread dorme, o se è stato solo prelazionato)
+    __u32 pid;
+    int   stack_id;
+    int   kstack_id;
+    __u32 _pad;
+};
+
+struct offcpu_key {
+    __u32 pid;
+    __u32 tid;
+    int   stack_id;
+    int   kstack_id;
+};
+
+struct offcpu_value {
+    __u64 blocked_ns;  // Tempo trascorso dormendo (disco, lock, rete, epoll_wait...)
+    __u64 runqueue_ns; // Tempo trascorso pronto a girare ma senza CPU
+    __u64 count;       // Numero di uscite dalla CPU
+};
+
+// Thread monitorati attualmente fuori dalla CPU, per TID.
+// LRU: se nonostante il controllo su PF_EXITING restano entry di thread spariti, cadono
+// le più vecchie invece di far fallire gli aggiornamenti
+struct {
+    __uint(type, BPF_MAP_TYPE_LRU_HASH);
+    __type(key, __u32);
+    __type(value, struct offcpu_start);
+    __uint(max_entries, 16384);
+} offcpu_start_map SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, struct offcpu_key);
+    __type(value, struct offcpu_value);
+    __uint(max_entries, 16384);
+} offcpu_counts SEC(".maps");
+
+SEC("tp_btf/sched_switch")
+int BPF_PROG(offcpu_switch, bool preempt, struct task_struct *prev, struct task_struct *next) {
+    __u64 now = bpf_ktime_get_ns();
+
+    // 1. Il thread che esce: siamo ancora nel suo contesto, quindi gli stack sono i suoi
+    // L'ultimo cambio di contesto di un thread che termina non ha un ritorno sulla CPU:
+    // la sua entry non verrebbe mai cancellata
+    __u32 prev_pid = BPF_CORE_READ(prev, tgid);
+    if (prev_pid != 0 && !(BPF_CORE_READ(prev, flags) & PF_EXITING) && is_target(prev_pid)) {
+        __u32 prev_tid = BPF_CORE_READ(prev, pid);
+        struct offcpu_start start = {
+            .off_ns = now,
+            // Prelazionato: è già eseguibile, tutta l'attesa sarà runqueue
+            .wakeup_ns = preempt ? now : 0,
+            .pid = prev_pid,
+            .stack_id = bpf_get_stackid(ctx, &profile_stacks, BPF_F_USER_STACK),
+            .kstack_id = bpf_get_stackid(ctx, &profile_stacks, 0),
+        };
+        if (start.stack_id < 0) {
+            count_stat(STAT_STACK_USER);
+        }
+        if (bpf_map_update_elem(&offcpu_start_map, &prev_tid, &start, BPF_ANY)) {
+            count_stat(STAT_THREAD_FULL);
+        }
+    }
+
+    // 2. Il thread che entra: se lo avevamo visto uscire, chiudiamo il conto
+    __u32 next_tid = BPF_CORE_READ(next, pid);
+    struct offcpu_start *start = bpf_map_lookup_elem(&offcpu_start_map, &next_tid);
+    if (!start) {
+        return 0;
+    }
+    __u64 ready_ns = start->wakeup_ns ? start->wakeup_ns : start->off_ns;
+    struct offcpu_key key = {
+        .pid = start->pid,
+        .tid = next_tid,
+        .stack_id = start->stack_id,
+        .kstack_id = start->kstack_id,
+    };
+    __u64 blocked = ready_ns - start->off_ns;
+    __u64 runqueue = now - ready_ns;
+    bpf_map_delete_elem(&offcpu_start_map, &next_tid);
+
+    struct offcpu_value *val = bpf_map_lookup_elem(&offcpu_counts, &key);
+    if (!val) {
+        struct offcpu_value init = { .blocked_ns = blocked, .runqueue_ns = runqueue, .count = 1 };
+        if (bpf_map_update_elem(&offcpu_counts, &key, &init, BPF_NOEXIST) == 0) {
+            return 0;
+        }
+        val = bpf_map_lookup_elem(&offcpu_counts, &key);
+        if (!val) {
+            count_stat(STAT_AGG_FULL);
+            return 0;
+        }
+    }
+    __sync_fetch_and_add(&val->blocked_ns, blocked);
+    __sync_fetch_and_add(&val->runqueue_ns, runqueue);
+    __sync_fetch_and_add(&val->count, 1);
+    return 0;
+}
+
+// Il thread torna eseguibile: da qui in poi il tempo fuori CPU è attesa in runqueue
+SEC("tp_btf/sched_wakeup")
+int BPF_PROG(offcpu_wakeup, struct task_struct *p) {
+    __u32 tid = BPF_CORE_READ(p, pid);
+    struct offcpu_start *start = bpf_map_lookup_elem(&offcpu_start_map, &tid);
+    if (start && !start->wakeup_ns) {
+        start->wakeup_ns = bpf_ktime_get_ns();
+    }
+    return 0;
+}
+
+// ---------------------------------------------------------------------------
+// MODALITÀ FUTEX: contesa sui lock
+// Ogni mutex/condvar di pthread (V8 platform, threadpool di libuv, addon nativi) finisce
+// in una futex quando è conteso. Per ogni indirizzo misuriamo quanto aspettano i thread
+// (FUTEX_WAIT e simili, da sys_enter a sys_exit) e con quale stack, e chi li sveglia
+// (FUTEX_WAKE e simili che hanno svegliato almeno un thread) con il suo stack.
+// I tracepoint syscalls/sys_*_futex hanno lo stesso layout di quelli raw_syscalls:
+// al posto di id c'è __syscall_nr (int + padding), e qui non lo usiamo
+// ---------------------------------------------------------------------------
+
+// Comandi futex (include/uapi/linux/futex.h), senza FUTEX_PRIVATE_FLAG e FUTEX_CLOCK_REALTIME
+#define FUTEX_CMD_MASK        ~(128 | 256)
+#define FUTEX_WAIT            0
+#define FUTEX_WAKE            1
+#define FUTEX_REQUEUE         3
+#define FUTEX_CMP_REQUEUE     4
+#define FUTEX_WAKE_OP         5
+#define FUTEX_LOCK_PI         6
+#define FUTEX_UNLOCK_PI       7
+#define FUTEX_WAIT_BITSET     9
+#define FUTEX_WAKE_BITSET     10
+#define FUTEX_WAIT_REQUEUE_PI 11
+#define FUTEX_LOCK_PI2        13
+
+#define EAGAIN 11
+
+enum futex_kind {
+    FUTEX_KIND_WAIT = 1,
+    FUTEX_KIND_WAKE = 2,
+};
+
+struct futex_enter {
+    __u64 uaddr;
+    __u64 start_ns;
+    __u32 kind; // enum futex_kind
+    __u32 cmd;
+};
+
+struct futex_key {
+    __u64 uaddr;
+    __u32 pid;
+    __u32 tid;
+    int   stack_id;
+    __u32 kind; // enum futex_kind
+};
+
+struct futex_value {
+    __u64 total_ns; // Attesa complessiva (solo FUTEX_KIND_WAIT)
+    __u64 max_ns;   // Attesa più lunga (solo FUTEX_KIND_WAIT)
+    __u64 count;    // Numero di attese o di risvegli riusciti
+    __u64 woken;    // Thread svegliati (solo FUTEX_KIND_WAKE)
+};
+
+// Futex in corso, per TID
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, __u32);
+    __type(value, struct futex_enter);
+    __uint(max_entries, 16384);
+} futex_enter_map SEC(".maps");
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, struct futex_key);
+    __type(value, struct futex_value);
+    __uint(max_entries, 16384);
+} futex_counts SEC(".maps");
+
+static __always_inline __u32 futex_op_kind(__u32 op) {
+    switch (op & FUTEX_CMD_MASK) {
+    case FUTEX_WAIT:
+    case FUTEX_WAIT_BITSET:
+    case FUTEX_LOCK_PI:
+    case FUTEX_LOCK_PI2:
+    case FUTEX_WAIT_REQUEUE_PI:
+        return FUTEX_KIND_WAIT;
+    case FUTEX_WAKE:
+    case FUTEX_WAKE_BITSET:
+    case FUTEX_WAKE_OP:
+    case FUTEX_REQUEUE:
+    case FUTEX_CMP_REQUEUE:
+    case FUTEX_UNLOCK_PI:
+        return FUTEX_KIND_WAKE;
+    }
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_enter_futex")
+int futex_enter(struct sys_enter_args *ctx) {
+    __u64 pid_tgid = bpf_get_current_pid_tgid();
+    __u32 pid = pid_tgid >> 32;
+    __u32 tid = (__u32)pid_tgid;
+    if (!is_target(pid)) {
+        return 0;
+    }
+
+    struct futex_enter enter = {
+        .uaddr = ctx->args[0],
+        .start_ns = bpf_ktime_get_ns(),
+        .kind = futex_op_kind(ctx->args[1]),
+        .cmd = ctx->args[1] & FUTEX_CMD_MASK,
+    };
+    if (!enter.kind) {
+        return 0;
+    }
+    bpf_map_update_elem(&futex_enter_map, &tid, &enter, BPF_ANY);
+    return 0;
+}
+
+SEC("tracepoint/syscalls/sys_exit_futex")
+int futex_exit(struct sys_exit_args *ctx) {
+    __u64 now = bpf_ktime_get_ns();
+    __u64 pid_tgid = bpf_get_current_pid_tgid();
+    __u32 tid = (__u32)pid_tgid;
+    struct futex_enter *enter = bpf_map_lookup_elem(&futex_enter_map, &tid);
+    if (!enter) {
+        return 0;
+    }
+    struct futex_enter e = *enter;
+    bpf_map_delete_elem(&futex_enter_map, &tid);
+
+    long ret = ctx->ret;
+    __u64 duration = now - e.start_ns;
+    __u64 woken = 0;
+    if (e.kind == FUTEX_KIND_WAIT) {
+        // EAGAIN: il valore era già cambiato, il thread non ha mai dormito
+        if (ret == -EAGAIN) {
+            return 0;
+        }
+    } else if (e.cmd == FUTEX_UNLOCK_PI) {
+        // Restituisce 0, ma la glibc la chiama solo se c'è qualcuno in attesa
+        if (ret != 0) {
+            return 0;
+        }
+        woken = 1;
+    } else {
+        // Teniamo solo i risvegli che hanno svegliato davvero qualcuno
+        if (ret <= 0) {
+            return 0;
+        }
+        woken = ret;
+    }
+
+    struct futex_key key = {
+        .uaddr = e.uaddr,
+        .pid = pid_tgid >> 32,
+        .tid = tid,
+        .stack_id = bpf_get_stackid(ctx, &profile_stacks, BPF_F_USER_STACK),
+        .kind = e.kind,
+    };
+    if (key.stack_id < 0) {
+        count_stat(STAT_STACK_USER);
+    }
+
+    struct futex_value *val = bpf_map_lookup_elem(&futex_counts, &key);
+    if (!val) {
+        struct futex_value init = {};
+        bpf_map_update_elem(&futex_counts, &key, &init, BPF_NOEXIST);
+        val = bpf_map_lookup_elem(&futex_counts, &key);
+        if (!val) {
+            count_stat(STAT_AGG_FULL);
+            return 0;
+        }
+    }
+    __sync_fetch_and_add(&val->count, 1);
+    if (e.kind == FUTEX_KIND_WAIT) {
+        __sync_fetch_and_add(&val->total_ns, duration);
+        if (duration > val->max_ns) {
+            val->max_ns = duration; // Non atomico: al peggio perdiamo un massimo quasi uguale
+        }
+    } else {
+        __sync_fetch_and_add(&val->woken, woken);
+    }
+    return 0;
+}
+
+// ---------------------------------------------------------------------------
+// PAGE FAULT UTENTE (--page-faults)
+// Le page fault non passano dalle syscall, ma nei servizi che usano molta memoria costano
+// parecchio. Contiamo i fault dei processi monitorati per (processo, stack utente, blocco
+// di 256KB dell'indirizzo): 256KB è la dimensione di una pagina dell'heap di V8, e basta
+// per capire in user space in quale regione di memoria è caduto il fault
+// ---------------------------------------------------------------------------
+
+#define FAULT_BUCKET_SHIFT 18
+#define X86_PF_WRITE       (1 << 1)
+
+// Struttura fissa per exceptions/page_fault_user (x86)
+struct page_fault_args {
+    __u16 common_type;
+    __u8  common_flags;
+    __u8  common_preempt_count;
+    __s32 common_pid;
+    unsigned long address;    // Indirizzo che ha causato il fault
+    unsigned long ip;         // Istruzione che lo ha causato
+    unsigned long error_code; // Bit X86_PF_*
+};
+
+struct fault_key {
+    __u32 pid;
+    int   stack_id;
+    __u64 bucket; // address >> FAULT_BUCKET_SHIFT
+};
+
+struct fault_value {
+    __u64 count;
+    __u64 writes; // Fault in scrittura (es. prima scrittura su una pagina appena allocata)
+};
+
+struct {
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, struct fault_key);
+    __type(value, struct fault_value);
+    __uint(max_entries, 16384);
+} fault_counts SEC(".maps");
+
+SEC("tracepoint/exceptions/page_fault_user")
+int trace_page_fault(struct page_fault_args *ctx) {
+    __u32 pid = bpf_get_current_pid_tgid() >> 32;
+    if (!is_target(pid)) {
+        return 0;
+    }
+
+    struct fault_key key = {
+        .pid = pid,
+        .stack_id = bpf_get_stackid(ctx, &profile_stacks, BPF_F_USER_STACK),
+        .bucket = ctx->address >> FAULT_BUCKET_SHIFT,
+    };
+    if (key.stack_id < 0) {
+        count_stat(STAT_STACK_USER);
+    }
+
+    struct fault_value *val = bpf_map_lookup_elem(&fault_counts, &key);
+    if (!val) {
+        struct fault_value init = {};
+        bpf_map_update_elem(&fault_counts, &key, &init, BPF_NOEXIST);
+        val = bpf_map_lookup_elem(&fault_counts, &key);
+        if (!val) {
+            count_stat(STAT_AGG_FULL);
+            return 0;
+        }
+    }
+    __sync_fetch_and_add(&val->count, 1);
+    if (ctx->error_code & X86_PF_WRITE) {
+        __sync_fetch_and_add(&val->writes, 1);
+    }
+    return 0;
+}
+
 char __license[] SEC("license") = "Dual MIT/GPL";
\ No newline at end of file
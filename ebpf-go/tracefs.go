@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+Il kernel descrive gli argomenti di ogni syscall nei file di formato di tracefs:
+/sys/kernel/tracing/events/syscalls/sys_enter_<nome>/format
+ES:
+	field:int __syscall_nr;	offset:8;	size:4;	signed:1;
+	field:int dfd;	offset:16;	size:8;	signed:0;
+	field:const char * filename;	offset:24;	size:8;	signed:0;
+	field:int flags;	offset:32;	size:8;	signed:0;
+Da qui ricaviamo nome e tipo di ogni argomento, senza scrivere a mano una tabella per syscall.
+*/
+
+// Percorsi possibili di tracefs (il secondo per i sistemi che lo montano solo sotto debugfs)
+var tracefsRoots = []string{"/sys/kernel/tracing", "/sys/kernel/debug/tracing"}
+
+// Alcune syscall hanno un nome diverso nell'evento di tracefs (es. stat -> sys_enter_newstat)
+var tracefsAliases = map[string]string{
+	"stat": "newstat", "fstat": "newfstat", "lstat": "newlstat", "uname": "newuname",
+}
+
+// SyscallArg rappresenta un argomento letto dal file di formato
+type SyscallArg struct {
+	Name   string
+	Type   string
+	Signed bool
+	Size   int
+}
+
+// SyscallSchemas contiene gli argomenti di ogni syscall, indicizzati per nome
+type SyscallSchemas map[string][]SyscallArg
+
+// LoadSyscallSchemas legge tutti i file sys_enter_*/format di tracefs.
+func LoadSyscallSchemas() (SyscallSchemas, error) {
+	for _, root := range tracefsRoots {
+		dirs, err := filepath.Glob(filepath.Join(root, "events/syscalls/sys_enter_*"))
+		if err != nil || len(dirs) == 0 {
+			continue
+		}
+		schemas := make(SyscallSchemas, len(dirs))
+		for _, dir := range dirs {
+			name := strings.TrimPrefix(filepath.Base(dir), "sys_enter_")
+			args, err := parseFormatFile(filepath.Join(dir, "format"))
+			if err != nil {
+				continue
+			}
+			schemas[name] = args
+		}
+		return schemas, nil
+	}
+	return nil, fmt.Errorf("tracefs non trovato (provati %s)", strings.Join(tracefsRoots, ", "))
+}
+
+// parseFormatFile estrae gli argomenti della syscall da un singolo file di formato
+func parseFormatFile(path string) ([]SyscallArg, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var args []SyscallArg
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "field:") {
+			continue
+		}
+		// La riga è divisa dai ';' in: "field:<tipo> <nome>", "offset:N", "size:N", "signed:N"
+		var decl string
+		var offset, size int
+		var signed bool
+		for _, part := range strings.Split(line, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(part), ":")
+			if !ok {
+				continue
+			}
+			switch key {
+			case "field":
+				decl = value
+			case "offset":
+				offset, _ = strconv.Atoi(value)
+			case "size":
+				size, _ = strconv.Atoi(value)
+			case "signed":
+				signed = value == "1"
+			}
+		}
+
+		// Gli argomenti veri partono dall'offset 16, dopo i campi common_* e __syscall_nr
+		if offset < 16 {
+			continue
+		}
+		sep := strings.LastIndexAny(decl, " *")
+		if sep < 0 {
+			continue
+		}
+		args = append(args, SyscallArg{
+			Name:   decl[sep+1:],
+			Type:   strings.TrimSpace(decl[:sep+1]),
+			Signed: signed,
+			Size:   size,
+		})
+	}
+	return args, scanner.Err()
+}
+
+// Lookup restituisce gli argomenti di una syscall a partire dal suo nome
+func (s SyscallSchemas) Lookup(name string) ([]SyscallArg, bool) {
+	if alias, ok := tracefsAliases[name]; ok {
+		name = alias
+	}
+	args, ok := s[name]
+	return args, ok
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+)
+
+/*
+Uprobe definite dall'utente (--uprobe / --uretprobe binario:simbolo).
+Oltre alle syscall si possono tracciare funzioni native qualsiasi: uv_fs_open o
+node::fs::Open nel binario node, SSL_write in libssl, le funzioni di un addon .node.
+Il binario può essere un percorso oppure un nome (node, libssl.so.3) cercato tra le
+librerie caricate dai processi monitorati. Gli eventi arrivano nel ring buffer come
+quelli delle syscall (EVENT_UPROBE), con stack, argomenti (i registri dei primi 6
+parametri) e, per --uretprobe, valore di ritorno e durata.
+*/
+
+// Deve corrispondere a UPROBE_WITH_RETURN in trace.c
+const uprobeWithReturn = 1 << 32
+
+type Uprobe struct {
+	Binary string // Percorso del file ELF
+	Symbol string // Nome indicato dall'utente (anche C++ demangled, es. node::fs::Open)
+	Return bool   // --uretprobe: evento al ritorno, con valore di ritorno e durata
+}
+
+type UprobeSet struct {
+	probes []*Uprobe // L'indice è l'id della sonda, passato al kernel nel cookie
+	links  []link.Link
+}
+
+// parseUprobeSpec separa "binario:simbolo". Il separatore è il primo ':', così il simbolo
+// può contenere "::" (es. node:node::fs::Open)
+func parseUprobeSpec(spec string) (bin, symbol string, err error) {
+	bin, symbol, ok := strings.Cut(spec, ":")
+	if !ok || bin == "" || symbol == "" {
+		return "", "", fmt.Errorf("%q non è nella forma binario:simbolo", spec)
+	}
+	return bin, symbol, nil
+}
+
+// resolveBinary trova il file di un binario indicato per nome: prima l'eseguibile dei
+// processi monitorati, poi le librerie caricate (es. libssl.so.3 o libssl), infine il PATH
+func resolveBinary(name string, pids []uint32, targets *TargetSet) (string, error) {
+	if strings.Contains(name, "/") {
+		return name, nil
+	}
+	for _, pid := range pids {
+		if exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid)); err == nil && filepath.Base(exe) == name {
+			return exe, nil
+		}
+	}
+	for _, pid := range pids {
+		for _, region := range targets.Symbolizer(pid).regions {
+			base := filepath.Base(region.Path)
+			if region.Path != "" && (base == name || strings.HasPrefix(base, name+".")) {
+				return region.Path, nil
+			}
+		}
+	}
+	return exec.LookPath(name)
+}
+
+// resolveUprobeSymbols restituisce i nomi ELF da agganciare: il simbolo stesso se esiste,
+// altrimenti tutte le funzioni il cui nome demangled corrisponde (gli overload C++)
+func resolveUprobeSymbols(path, symbol string) ([]string, error) {
+	idx, err := loadELFSymbols(path)
+	if err != nil {
+		return nil, err
+	}
+	matches := idx.Find(symbol)
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("funzione %s non trovata in %s", symbol, path)
+	}
+	return matches, nil
+}
+
+// AttachUprobes aggancia uprobe_entry (e uprobe_return per le uretprobe) a ogni specifica
+func AttachUprobes(entry, ret *ebpf.Program, specs, retSpecs []string, pids []uint32, targets *TargetSet) (*UprobeSet, error) {
+	set := &UprobeSet{}
+	add := func(spec string, withReturn bool) error {
+		name, symbol, err := parseUprobeSpec(spec)
+		if err != nil {
+			return err
+		}
+		path, err := resolveBinary(name, pids, targets)
+		if err != nil {
+			return fmt.Errorf("binario %s non trovato: %w", name, err)
+		}
+		symbols, err := resolveUprobeSymbols(path, symbol)
+		if err != nil {
+			return err
+		}
+		ex, err := link.OpenExecutable(path)
+		if err != nil {
+			return err
+		}
+
+		cookie := uint64(len(set.probes))
+		if withReturn {
+			cookie |= uprobeWithReturn
+		}
+		set.probes = append(set.probes, &Uprobe{Binary: path, Symbol: symbol, Return: withReturn})
+		for _, sym := range symbols {
+			l, err := ex.Uprobe(sym, entry, &link.UprobeOptions{Cookie: cookie})
+			if err != nil {
+				return fmt.Errorf("uprobe %s: %w", spec, err)
+			}
+			set.links = append(set.links, l)
+			if !withReturn {
+				continue
+			}
+			l, err = ex.Uretprobe(sym, ret, &link.UprobeOptions{Cookie: cookie})
+			if err != nil {
+				return fmt.Errorf("uretprobe %s: %w", spec, err)
+			}
+			set.links = append(set.links, l)
+		}
+		return nil
+	}
+
+	for _, spec := range specs {
+		if err := add(spec, false); err != nil {
+			set.Close()
+			return nil, err
+		}
+	}
+	for _, spec := range retSpecs {
+		if err := add(spec, true); err != nil {
+			set.Close()
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// Close sgancia tutte le sonde
+func (s *UprobeSet) Close() {
+	for _, l := range s.links {
+		l.Close()
+	}
+	s.links = nil
+}
+
+// Describe restituisce "simbolo (binario)" per il messaggio di avvio e per gli eventi
+func (p *Uprobe) Describe() string {
+	return fmt.Sprintf("%s (%s)", p.Symbol, filepath.Base(p.Binary))
+}
+
+// Format produce la forma compatta dell'evento, es. "SSL_write (libssl.so.3) -> 517 (35µs)"
+func (s *UprobeSet) Format(info SyscallInfo) string {
+	if int(info.SyscallId) >= len(s.probes) {
+		return fmt.Sprintf("sonda %d sconosciuta", info.SyscallId)
+	}
+	probe := s.probes[info.SyscallId]
+	if !probe.Return {
+		return probe.Describe()
+	}
+	return fmt.Sprintf("%s -> %d (0x%x) (%s)", probe.Describe(), info.Ret, uint64(info.Ret), formatDuration(info.DurationNs))
+}
+
+// formatUprobeArgs stampa i registri dei primi 6 parametri: senza i tipi della funzione
+// non sappiamo quanti sono davvero, né se sono puntatori
+func formatUprobeArgs(info SyscallInfo) string {
+	args := make([]string, len(info.Args))
+	for i, arg := range info.Args {
+		args[i] = fmt.Sprintf("arg%d=0x%x", i, arg)
+	}
+	return strings.Join(args, " ")
+}